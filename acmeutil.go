@@ -4,17 +4,36 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"9fans.net/go/acme"
+	"gitlab.com/neosimsim/acme-agda/agda"
 )
 
 type Range struct {
-Start int
+	Start int
 	End   int
 }
 
+// The operations on Acme windows used by acme-agda. Implemented by *acme.Win,
+// so the frontend can be tested without a running Acme.
+type Window interface {
+	ID() int
+	Name(format string, args ...interface{}) error
+	Addr(format string, args ...interface{}) error
+	ReadAddr() (q0, q1 int, err error)
+	Ctl(format string, args ...interface{}) error
+	Write(file string, b []byte) (n int, err error)
+	ReadAll(file string) ([]byte, error)
+	Selection() string
+	EventChan() <-chan *acme.Event
+	WriteEvent(e *acme.Event) error
+	CloseFiles()
+}
+
 // Returns the Acme window from which the application was executed,
 // i.e. the window with ID matching the evironment variable winid.
 func CallingWindow() (*acme.Win, error) {
@@ -25,7 +44,7 @@ func CallingWindow() (*acme.Win, error) {
 	}
 }
 
-func WindowName(win *acme.Win) (string, error) {
+func WindowName(win Window) (string, error) {
 	if windows, err := acme.Windows(); err != nil {
 		return "", err
 	} else {
@@ -38,7 +57,7 @@ func WindowName(win *acme.Win) (string, error) {
 	}
 }
 
-func SelectCurrentLine(win *acme.Win) error {
+func SelectCurrentLine(win Window) error {
 	err := win.Ctl("addr=dot")
 	if err != nil {
 		return err
@@ -55,7 +74,7 @@ func SelectCurrentLine(win *acme.Win) error {
 }
 
 // Select the goal "under the cursor". Using backwords and forward search from dot.
-func SelectGoal(win *acme.Win) error {
+func SelectGoal(win Window) error {
 	err := win.Ctl("addr=dot")
 	if err != nil {
 		return err
@@ -73,7 +92,7 @@ func SelectGoal(win *acme.Win) error {
 
 const goalAddress = `/( \?( |$)|{!.*!})`
 
-func GoalRanges(win *acme.Win) ([]Range, error) {
+func GoalRanges(win Window) ([]Range, error) {
 	err := win.Addr("#0")
 	if err != nil {
 		return nil, err
@@ -104,7 +123,7 @@ func GoalRanges(win *acme.Win) ([]Range, error) {
 }
 
 // Sets dot to the the next goal
-func NextGoal(win *acme.Win) error {
+func NextGoal(win Window) error {
 	err := win.Ctl("addr=dot")
 	if err != nil {
 		return err
@@ -120,7 +139,43 @@ func NextGoal(win *acme.Win) error {
 	return win.Ctl("show")
 }
 
-func ReplaceSelection(win *acme.Win, text string) error {
+// Replaces the goal idx, counting the goals in the order of the text,
+// by the result agda gave for its content.
+func GiveGoal(win Window, idx int, result agda.GiveResult) error {
+	ranges, err := GoalRanges(win)
+	if err != nil {
+		return err
+	}
+	if idx < 0 || idx >= len(ranges) {
+		return fmt.Errorf("there is no goal %d", idx)
+	}
+	goal := ranges[idx]
+	if err := win.Addr("#%d,#%d", goal.Start, goal.End); err != nil {
+		return err
+	}
+	text, err := win.ReadAll("xdata")
+	if err != nil {
+		return err
+	}
+	// goalAddress matches the spaces around a ?, which must stay.
+	goal.Start += len(text) - len(strings.TrimLeft(string(text), " "))
+	goal.End -= len(text) - len(strings.TrimRight(string(text), " "))
+	give := result.Str
+	if give == "" {
+		give = strings.TrimSpace(string(text))
+		give = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(give, "{!"), "!}"))
+		if result.Paren {
+			give = "(" + give + ")"
+		}
+	}
+	if err := win.Addr("#%d,#%d", goal.Start, goal.End); err != nil {
+		return err
+	}
+	_, err = win.Write("data", []byte(give))
+	return err
+}
+
+func ReplaceSelection(win Window, text string) error {
 	err := win.Ctl("addr=dot")
 	if err != nil {
 		return err
@@ -129,8 +184,26 @@ func ReplaceSelection(win *acme.Win, text string) error {
 	return err
 }
 
+// Calls handle for every event in the acme log. Returns when the log
+// cannot be read anymore.
+func watchLog(handle func(acme.LogEvent)) error {
+	logReader, err := acme.Log()
+	if err != nil {
+		return fmt.Errorf("cannot open acme log: %w", err)
+	}
+	defer logReader.Close()
+	for {
+		event, err := logReader.Read()
+		if err != nil {
+			return fmt.Errorf("cannot read acme log: %w", err)
+		}
+		debugPrint("acme log: %v", event)
+		handle(event)
+	}
+}
+
 // For some reasons, I do not understand yet, writing the address the first
 // time has no effect. After calling this function everything works as I expect.
-func ResetAddr(win *acme.Win) error {
+func ResetAddr(win Window) error {
 	return win.Addr("#0")
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gitlab.com/neosimsim/acme-agda/agda"
+)
+
+const goalsSource = `module Goals where
+
+open import Agda.Builtin.Nat
+
+f : Nat → Nat
+f n = {! n !}
+
+g : Nat → Nat
+g n = ?
+h : Nat
+h = {!!}
+`
+
+func TestFakeWindowAddresses(t *testing.T) {
+	win := newFakeWindow("one\ntwo\nthree\n")
+	tests := []struct {
+		addr string
+		from Range
+		want string
+	}{
+		{"#4,#7", Range{}, "two"},
+		{",", Range{}, "one\ntwo\nthree\n"},
+		{"2", Range{}, "two\n"},
+		{"-+", Range{Start: 5, End: 5}, "two\n"},
+		{"-+", Range{Start: 1, End: 1}, "one\n"},
+		{"/t.o/", Range{}, "two"},
+		{"/one/", Range{Start: 5, End: 5}, "one"},
+		{"-/o/", Range{Start: 8, End: 8}, "o"},
+		{`#8-/t/,#8`, Range{}, "two\n"},
+	}
+	for _, test := range tests {
+		win.addr = test.from
+		if err := win.Addr(test.addr); err != nil {
+			t.Errorf("%s: %s", test.addr, err)
+			continue
+		}
+		if got, _ := win.ReadAll("xdata"); string(got) != test.want {
+			t.Errorf("%s from %v: got %q, want %q", test.addr, test.from, got, test.want)
+		}
+	}
+}
+
+func TestSelectGoal(t *testing.T) {
+	win := newFakeWindow(goalsSource)
+	win.PlaceDot("n !}")
+	if err := SelectGoal(win); err != nil {
+		t.Fatal(err)
+	}
+	if got := win.Dot(); got != "{! n !}" {
+		t.Errorf("selected %q, want the goal", got)
+	}
+}
+
+func TestGoalRanges(t *testing.T) {
+	win := newFakeWindow(goalsSource)
+	ranges, err := GoalRanges(win)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var goals []string
+	for _, r := range ranges {
+		win.addr = r
+		text, _ := win.ReadAll("xdata")
+		goals = append(goals, string(text))
+	}
+	if want := []string{"{! n !}", " ?", "{!!}"}; !reflect.DeepEqual(goals, want) {
+		t.Errorf("got goals %q, want %q", goals, want)
+	}
+}
+
+func TestNextGoal(t *testing.T) {
+	win := newFakeWindow(goalsSource)
+	for _, want := range []string{"{! n !}", " ?", "{!!}", "{! n !}"} {
+		if err := NextGoal(win); err != nil {
+			t.Fatal(err)
+		}
+		if got := win.Dot(); got != want {
+			t.Errorf("next goal is %q, want %q", got, want)
+		}
+	}
+}
+
+func TestReplaceCurrentLine(t *testing.T) {
+	win := newFakeWindow(goalsSource)
+	win.SelectText("{! n !}")
+	if err := SelectCurrentLine(win); err != nil {
+		t.Fatal(err)
+	}
+	if got := win.Dot(); got != "f n = {! n !}\n" {
+		t.Fatalf("selected %q, want the line of the goal", got)
+	}
+	if err := ReplaceSelection(win, "f zero = ?\nf (suc n) = ?\n"); err != nil {
+		t.Fatal(err)
+	}
+	want := `module Goals where
+
+open import Agda.Builtin.Nat
+
+f : Nat → Nat
+f zero = ?
+f (suc n) = ?
+
+g : Nat → Nat
+g n = ?
+h : Nat
+h = {!!}
+`
+	if got := win.Body(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGiveGoal(t *testing.T) {
+	win := newFakeWindow(goalsSource)
+	if err := GiveGoal(win, 0, agda.GiveResult{Paren: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := GiveGoal(win, 0, agda.GiveResult{Str: "suc ?"}); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(goalsSource, "f n = {! n !}", "f n = (n)", 1)
+	want = strings.Replace(want, "g n = ?", "g n = suc ?", 1)
+	if got := win.Body(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGiveGoalFollowedByText(t *testing.T) {
+	win := newFakeWindow("x = ? + ?\n")
+	if err := GiveGoal(win, 0, agda.GiveResult{Str: "suc ?"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := win.Body(), "x = suc ? + ?\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
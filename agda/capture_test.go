@@ -0,0 +1,140 @@
+package agda
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var agdaPath = flag.String("agda", "", "capture the responses of this agda into testdata/responses")
+
+// Commands whose responses TestCaptureResponses records, run on
+// testdata/Capture.agda after it has been loaded
+var captureCommands = []Command{
+	Cmd_goal_type{Rewrite: "Simplified", Goal: 0},
+	Cmd_infer{Rewrite: "Simplified", Goal: 0, Expr: "n"},
+	Cmd_infer_toplevel{Rewrite: "Simplified", Expr: "zero"},
+	Cmd_infer_toplevel{Rewrite: "Simplified", Expr: "undefined"},
+	Cmd_why_in_scope_toplevel{Name: "zero"},
+	Cmd_show_version{},
+	Cmd_give{Force: WithoutForce, Goal: 1},
+	Cmd_make_case{Goal: 0, Expr: "n"},
+}
+
+// Runs the agda given by -agda on testdata/Capture.agda and writes the
+// first response of every kind to testdata/responses/VERSION, along with
+// its golden file. Responses the session does not produce are left alone.
+func TestCaptureResponses(t *testing.T) {
+	if *agdaPath == "" {
+		t.Skip("no -agda to capture the responses of")
+	}
+	source, err := ioutil.ReadFile("testdata/Capture.agda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "acme-agda-capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "Capture.agda")
+	if err := ioutil.WriteFile(filename, source, 0666); err != nil {
+		t.Fatal(err)
+	}
+	client, err := Start(*agdaPath, filename, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var session strings.Builder
+	if err := client.Record(&session); err != nil {
+		t.Fatal(err)
+	}
+	for _, indirect := range []bool{false, true} {
+		client.SetIndirectHighlighting(indirect)
+		if err := client.LoadFile(); err != nil {
+			t.Fatal(err)
+		}
+		responsesUntilPrompt(t, client)
+	}
+	for _, cmd := range captureCommands {
+		if err := client.Send(cmd); err != nil {
+			t.Fatal(err)
+		}
+		responsesUntilPrompt(t, client)
+	}
+	version := client.Version()
+	exit(t, client)
+
+	responsesDir := filepath.Join("testdata", "responses", version.String())
+	if err := os.MkdirAll(responsesDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	captured := make(map[string]bool)
+	for _, line := range strings.Split(session.String(), "\n") {
+		if !strings.HasPrefix(line, "< ") {
+			continue // not received
+		}
+		line = strings.TrimPrefix(line, "< ")
+		line = strings.TrimSpace(strings.TrimPrefix(line, "JSON> "))
+		name, ok := responseName(line)
+		if !ok || captured[name] {
+			continue
+		}
+		captured[name] = true
+		path := filepath.Join(responsesDir, name)
+		if err := ioutil.WriteFile(path+".json", []byte(line+"\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		golden := decoded(parseResponse(line, version))
+		if err := ioutil.WriteFile(path+".golden", []byte(golden), 0666); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("captured %s", path)
+	}
+}
+
+var wordStart = regexp.MustCompile(`[A-Z][a-z]*`)
+
+// Returns the file name of the corpus for response, e.g. all-goals-warnings
+// for the DisplayInfo AllGoalsWarnings.
+func responseName(response string) (string, bool) {
+	var kinds struct {
+		Kind   string
+		Direct bool
+		Info   struct {
+			Kind     string
+			GoalInfo struct{ Kind string }
+		}
+	}
+	if err := json.Unmarshal([]byte(response), &kinds); err != nil || kinds.Kind == "" {
+		return "", false
+	}
+	switch kinds.Kind {
+	case "DisplayInfo":
+		if kinds.Info.Kind != "GoalSpecific" {
+			return kebab(kinds.Info.Kind), true
+		}
+		name := kebab(kinds.Info.GoalInfo.Kind)
+		if !strings.HasPrefix(name, "goal-") {
+			name = "goal-" + name
+		}
+		return name, true
+	case "HighlightingInfo":
+		if kinds.Direct {
+			return "highlighting-direct", true
+		}
+		return "highlighting-indirect", true
+	default:
+		return kebab(kinds.Kind), true
+	}
+}
+
+// Returns the words of the CamelCase s in lower case joined by -.
+func kebab(s string) string {
+	return strings.ToLower(strings.Join(wordStart.FindAllString(s, -1), "-"))
+}
@@ -0,0 +1,448 @@
+// Package agda is a client for Agda's JSON interaction mode, the protocol
+// editors use to load Agda files and work on their goals.
+//
+// Start runs agda for a file. Commands, declared in commands.go, are sent
+// with Client.Send or one of the shorthands like Client.LoadFile. The
+// decoded responses, see response.go, are received from Client.Responses.
+// Client.Exit shuts agda down:
+//
+//	client, err := agda.Start("agda", "/path/to/Foo.agda", nil, nil)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer client.Exit()
+//	client.LoadFile()
+//	for response := range client.Responses() {
+//		...
+//	}
+package agda
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const prompt = "JSON> "
+
+// Highlighting is requested to learn the definition sites of names,
+// but not for the expressions typed into goals.
+const highlightingLevel = HighlightingNonInteractive
+
+// A client for one Agda file. Several clients may share one agda process,
+// see Open.
+type Client struct {
+	filename  string
+	loadArgs  []string
+	process   *agdaProcess
+	responses chan Response
+	// Closed by Close, responses are dropped from then on
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newClient(filename string, loadArgs []string, process *agdaProcess) *Client {
+	return &Client{filename: filename, loadArgs: loadArgs, process: process, responses: make(chan Response), closed: make(chan struct{})}
+}
+
+// An agda process serving one or more files. Agda only keeps one file loaded
+// at a time, so the process remembers which file is loaded and to which
+// client the responses are delivered.
+type agdaProcess struct {
+	cmd     *exec.Cmd
+	version Version
+	stdout  io.ReadCloser
+	stderr  io.ReadCloser
+	stdin   io.WriteCloser
+	// The last lines of stderr
+	stderrRing lineRing
+	transcript transcript
+	// Closed when agda's output is exhausted
+	eof        chan struct{}
+	stderrDone chan struct{}
+
+	sync.Mutex
+	loaded string
+	// The client which sent the last command
+	owner *Client
+	// The client Start returned, the owner again once the owner is closed
+	first *Client
+	// The clients of the commands agda has not completed yet, oldest first
+	pending []*Client
+	// Set once agda printed its first prompt
+	ready              bool
+	highlightingMethod HighlightingMethod
+	// Set by Exit, agda exiting is expected
+	exiting bool
+}
+
+// Called with debugging output, discards it by default.
+var Debugf = func(format string, args ...interface{}) {}
+
+// Time agda is given to exit before it is killed
+const exitTimeout = 5 * time.Second
+
+// Starts agda in JSON interaction mode and returns a client for filename.
+// agdaArgs are passed to agda on the command line, loadArgs are sent as
+// options with every Cmd_load. Fails if the version of agda is not supported.
+func Start(agdaCmdPath, filename string, agdaArgs, loadArgs []string) (*Client, error) {
+	version, err := QueryVersion(agdaCmdPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := version.Supported(); err != nil {
+		return nil, err
+	}
+	if MaxVersion.Before(version) {
+		log.Printf("agda %s is newer than agda %s acme-agda was tested with", version, MaxVersion)
+	}
+	agdaCmd := exec.Command(agdaCmdPath, append([]string{"--interaction-json"}, agdaArgs...)...)
+	stdin, err := agdaCmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := agdaCmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := agdaCmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := agdaCmd.Start(); err != nil {
+		return nil, err
+	}
+	process := &agdaProcess{
+		cmd:                agdaCmd,
+		version:            version,
+		stdin:              stdin,
+		stdout:             stdout,
+		stderr:             stderr,
+		eof:                make(chan struct{}),
+		stderrDone:         make(chan struct{}),
+		highlightingMethod: HighlightingDirect,
+	}
+	a := newClient(filename, loadArgs, process)
+	process.owner, process.first = a, a
+	go process.readStderr()
+	go process.readStdout()
+	return a, nil
+}
+
+// Reads and delivers agda's responses until agda closes its output.
+func (process *agdaProcess) readStdout() {
+	reader := bufio.NewReader(process.stdout)
+	for {
+		line, err := readLine(reader)
+		if line != "" {
+			process.transcript.record("<", line)
+		}
+		process.handleLine(line)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("error reading agda output: %s", err)
+			}
+			Debugf("agda closed its output")
+			close(process.eof)
+			process.exited()
+			return
+		}
+	}
+}
+
+// Reads a line of agda's output. Agda does not end its prompt with a new
+// line, so prompts are returned as soon as agda waits for the next command.
+func readLine(reader *bufio.Reader) (string, error) {
+	var line strings.Builder
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return line.String(), err
+		}
+		line.WriteByte(b)
+		if b == '\n' {
+			return line.String(), nil
+		}
+		if reader.Buffered() == 0 && strings.HasSuffix(line.String(), prompt) &&
+			strings.ReplaceAll(line.String(), prompt, "") == "" {
+			return line.String(), nil
+		}
+	}
+}
+
+// Handles one line of agda's output: Agda prints a prompt whenever it waits
+// for the next command, so the response lines may be preceded by prompts.
+// Lines which are no JSON, like debug output, are logged only.
+func (process *agdaProcess) handleLine(line string) {
+	for {
+		line = strings.TrimLeft(line, " ")
+		if !strings.HasPrefix(line, strings.TrimSpace(prompt)) {
+			break
+		}
+		line = strings.TrimPrefix(line, strings.TrimSpace(prompt))
+		process.prompt()
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	if !strings.HasPrefix(line, "{") {
+		Debugf("agda: %s", line)
+		return
+	}
+	if response, err := parseResponse(line, process.version); err != nil {
+		log.Printf("error parsing response: %s", err)
+	} else if response == nil {
+		Debugf("ignoring response: %s", line)
+	} else {
+		process.deliver(response)
+	}
+}
+
+// Handles a prompt. Except for the first one, each prompt completes the
+// oldest pending command.
+func (process *agdaProcess) prompt() {
+	process.Lock()
+	if !process.ready {
+		process.ready = true
+		process.Unlock()
+		return
+	}
+	if len(process.pending) == 0 {
+		process.Unlock()
+		Debugf("prompt without pending command")
+		return
+	}
+	client := process.pending[0]
+	process.pending = process.pending[1:]
+	process.Unlock()
+	client.receive(Resp_Prompt{})
+}
+
+// Sends response to the client whose command agda is executing, or to the
+// client which sent the last command if none is pending.
+func (process *agdaProcess) deliver(response Response) {
+	process.Lock()
+	client := process.owner
+	if len(process.pending) > 0 {
+		client = process.pending[0]
+	}
+	process.Unlock()
+	client.receive(response)
+}
+
+// Passes response to the reader of Responses, unless a is closed.
+func (a *Client) receive(response Response) {
+	select {
+	case a.responses <- response:
+	case <-a.closed:
+		Debugf("dropping %T for the closed client of %s", response, a.filename)
+	}
+}
+
+// Stops delivering responses to a, e.g. once a request is answered and
+// nobody reads Responses anymore. Responses agda sends without being
+// asked are delivered to the client Start returned again.
+func (a *Client) Close() {
+	a.closeOnce.Do(func() { close(a.closed) })
+	a.process.Lock()
+	defer a.process.Unlock()
+	if a.process.owner == a {
+		a.process.owner = a.process.first
+	}
+}
+
+// Returns a channel which is closed once a is closed.
+func (a *Client) Done() <-chan struct{} {
+	return a.closed
+}
+
+// Reports an unexpected exit of agda, including the end of its stderr.
+func (process *agdaProcess) exited() {
+	select {
+	case <-process.stderrDone:
+	case <-time.After(time.Second):
+	}
+	process.Lock()
+	exiting := process.exiting
+	process.Unlock()
+	if !exiting {
+		process.deliver(Resp_Exited{Stderr: process.stderrRing.String()})
+	}
+}
+
+// Returns the last lines agda wrote to its standard error.
+func (a *Client) Stderr() string {
+	return a.process.stderrRing.String()
+}
+
+// Returns a client for filename sharing the agda process of a.
+// The responses to the commands of the new client are delivered on its own
+// Responses channel.
+func (a *Client) Open(filename string) *Client {
+	return newClient(filename, a.loadArgs, a.process)
+}
+
+// Lets agda send highlighting information in temporary files instead of
+// the responses, which keeps the responses small for large modules.
+// The files are read with ReadHighlightingFile.
+func (a *Client) SetIndirectHighlighting(indirect bool) {
+	a.process.Lock()
+	defer a.process.Unlock()
+	if indirect {
+		a.process.highlightingMethod = HighlightingIndirect
+	} else {
+		a.process.highlightingMethod = HighlightingDirect
+	}
+}
+
+// Returns the version of agda, as reported by agda --version.
+func (a *Client) Version() Version {
+	return a.process.version
+}
+
+// Reports whether agda has a's file loaded, i.e. whether the goal indices
+// of agda refer to a's file.
+func (a *Client) Loaded() bool {
+	a.process.Lock()
+	defer a.process.Unlock()
+	return a.process.loaded == a.filename
+}
+
+func (a *Client) Filename() string {
+	return a.filename
+}
+
+func (a *Client) Responses() <-chan Response {
+	return a.responses
+}
+
+// Sends cmd to agda. If agda has another file loaded, a's file is loaded
+// first, as goal indices refer to the loaded file. The responses to the
+// command are delivered to a, followed by Resp_Prompt.
+func (a *Client) Send(cmd Command) error {
+	a.process.Lock()
+	defer a.process.Unlock()
+	if a.process.loaded != a.filename && needsLoadedFile(cmd) {
+		if err := a.process.write(a, a.loadCommand()); err != nil {
+			return err
+		}
+	}
+	return a.process.write(a, cmd)
+}
+
+// Writes cmd on behalf of client. Cmd_abort and Cmd_exit are handled by
+// agda right away, all other commands are queued until agda completes them.
+func (process *agdaProcess) write(client *Client, cmd Command) error {
+	if err := process.version.supports(cmd); err != nil {
+		return err
+	}
+	iotcm := IOTCM{File: client.filename, Level: highlightingLevel, Method: process.highlightingMethod, Command: cmd}
+	line, err := iotcm.Encode()
+	if err != nil {
+		return err
+	}
+	if _, ok := cmd.(Cmd_load); ok {
+		process.loaded = client.filename
+	}
+	switch cmd.(type) {
+	case Cmd_abort, Cmd_exit:
+		return process.writeLine(client, line, false)
+	default:
+		return process.writeLine(client, line, true)
+	}
+}
+
+// Writes the IOTCM line on behalf of client, queueing it unless agda
+// handles it right away.
+func (process *agdaProcess) writeLine(client *Client, line string, queued bool) error {
+	Debugf("sending command: %s", line)
+	process.transcript.record(">", line)
+	if _, err := io.WriteString(process.stdin, line+"\n"); err != nil { // The new line is important
+		return err
+	}
+	process.owner = client
+	if queued {
+		process.pending = append(process.pending, client)
+	}
+	return nil
+}
+
+// Sends iotcm, a complete IOTCM line, as it is, e.g. a command this
+// package does not know. Like Send, it loads a's file first if agda has
+// another file loaded.
+func (a *Client) SendIOTCM(iotcm string) error {
+	a.process.Lock()
+	defer a.process.Unlock()
+	if a.process.loaded != a.filename {
+		if err := a.process.write(a, a.loadCommand()); err != nil {
+			return err
+		}
+	}
+	return a.process.writeLine(a, strings.TrimSpace(iotcm), true)
+}
+
+func (a *Client) loadCommand(args ...string) Cmd_load {
+	return Cmd_load{File: a.filename, Options: append(append([]string{}, a.loadArgs...), args...)}
+}
+
+// Loads the file, passing the load options given to Start followed by args.
+func (a *Client) LoadFile(args ...string) error {
+	return a.Send(a.loadCommand(args...))
+}
+
+func (a *Client) CaseSplit(goalIdx int, varName string) error {
+	return a.Send(Cmd_make_case{Goal: goalIdx, Expr: varName})
+}
+
+func (a *Client) RefineHole(goalIdx int, content string) error {
+	return a.Send(Cmd_refine{Goal: goalIdx, Expr: content})
+}
+
+func (a *Client) GoalType(goalIdx int, rewrite Rewrite) error {
+	return a.Send(Cmd_goal_type_context{Rewrite: rewrite, Goal: goalIdx})
+}
+
+// Aborts the command agda is currently executing, if any.
+// Unlike the other commands, Abort does not wait for a's file to be loaded.
+func (a *Client) Abort() error {
+	a.process.Lock()
+	defer a.process.Unlock()
+	return a.process.write(a, Cmd_abort{})
+}
+
+// Asks agda to exit and waits for it, killing agda if it does not exit
+// in time. All clients sharing the agda process are affected.
+func (a *Client) Exit() error {
+	a.process.Lock()
+	a.process.exiting = true
+	if err := a.process.write(a, Cmd_exit{}); err != nil {
+		Debugf("could not send exit command: %s", err)
+	}
+	a.process.stdin.Close()
+	a.process.Unlock()
+	select {
+	case <-a.process.eof:
+	case <-time.After(exitTimeout):
+		log.Printf("agda did not exit in time, killing it")
+		a.Kill()
+	}
+	a.process.transcript.close()
+	if a.process.cmd == nil { // replayed
+		return nil
+	}
+	return a.process.cmd.Wait()
+}
+
+func (a *Client) Kill() {
+	if a.process.cmd == nil {
+		return
+	}
+	if err := a.process.cmd.Process.Kill(); err != nil {
+		log.Printf("could not kill agda: %s", err)
+	}
+}
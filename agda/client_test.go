@@ -0,0 +1,126 @@
+package agda
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Starts a client for Nat.agda talking to a fake agda playing script.
+func startFake(t *testing.T, script string) *Client {
+	t.Helper()
+	client, err := Start(fakeAgdaPath(t, script), "Nat.agda", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// Returns the responses to the next command up to its prompt.
+func responsesUntilPrompt(t *testing.T, client *Client) []Response {
+	t.Helper()
+	var responses []Response
+	for {
+		select {
+		case r := <-client.Responses():
+			switch r := r.(type) {
+			case Resp_Prompt:
+				return responses
+			case Resp_Exited:
+				t.Fatalf("agda exited:\n%s", r.Stderr)
+			default:
+				responses = append(responses, r)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no prompt after %v", responses)
+		}
+	}
+}
+
+func exit(t *testing.T, client *Client) {
+	t.Helper()
+	if err := client.Exit(); err != nil {
+		t.Errorf("agda did not exit cleanly: %s\n%s", err, client.Stderr())
+	}
+}
+
+var goal0 = InteractionId{Id: 0, Range: AgdaRange{{
+	Start: Position{Pos: 54, Line: 5, Col: 7},
+	End:   Position{Pos: 61, Line: 5, Col: 14},
+}}}
+
+func TestLoadFile(t *testing.T) {
+	client := startFake(t, "load")
+	defer exit(t, client)
+	if v := client.Version(); v != (Version{2, 6, 2}) {
+		t.Errorf("got version %s", v)
+	}
+	if err := client.LoadFile(); err != nil {
+		t.Fatal(err)
+	}
+	want := []Response{
+		Resp_ClearHighlighting{},
+		Resp_Status{Status: Status{}},
+		Resp_InteractionPoints{InteractionPoints: []InteractionId{goal0}},
+	}
+	if got := responsesUntilPrompt(t, client); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestCaseSplit(t *testing.T) {
+	client := startFake(t, "case")
+	defer exit(t, client)
+	if err := client.CaseSplit(0, "n"); err != nil {
+		t.Fatal(err)
+	}
+	responsesUntilPrompt(t, client) // the file is loaded first
+	want := []Response{Resp_MakeCase{
+		InteractionPoint: goal0,
+		Variant:          "Function",
+		Clauses:          []string{"f zero = ?", "f (suc n) = ?"},
+	}}
+	if got := responsesUntilPrompt(t, client); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestRefineHole(t *testing.T) {
+	client := startFake(t, "refine")
+	defer exit(t, client)
+	if err := client.LoadFile(); err != nil {
+		t.Fatal(err)
+	}
+	responsesUntilPrompt(t, client)
+	if err := client.RefineHole(0, "suc"); err != nil {
+		t.Fatal(err)
+	}
+	got := responsesUntilPrompt(t, client)
+	if len(got) != 2 {
+		t.Fatalf("got %#v, want GiveAction and InteractionPoints", got)
+	}
+	if want := (Resp_GiveAction{InteractionPoint: goal0, GiveResult: GiveResult{Str: "suc ?"}}); !reflect.DeepEqual(got[0], want) {
+		t.Errorf("got %#v\nwant %#v", got[0], want)
+	}
+}
+
+func TestUnexpectedCommand(t *testing.T) {
+	client := startFake(t, "refine")
+	defer client.Kill()
+	if err := client.LoadFile(); err != nil {
+		t.Fatal(err)
+	}
+	responsesUntilPrompt(t, client)
+	if err := client.CaseSplit(0, "n"); err != nil {
+		t.Fatal(err)
+	}
+	for r := range client.Responses() {
+		if exited, ok := r.(Resp_Exited); ok {
+			if !strings.Contains(exited.Stderr, "Cmd_make_case") {
+				t.Errorf("agda exited with\n%s", exited.Stderr)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,367 @@
+// Typed representation of agda's interaction commands.
+//
+// A command is declared as struct named after the constructor of Agda's
+// Interaction type, embedding command. Its exported fields are the
+// arguments of the constructor, in order:
+//
+// find $AGDA_SRCDIR -type f | xargs grep -n '^data Interaction'
+package agda
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// An IOTCM, the envelope of every command sent to agda.
+type IOTCM struct {
+	File    string
+	Level   HighlightingLevel
+	Method  HighlightingMethod
+	Command Command
+}
+
+// find $AGDA_SRCDIR -type f | xargs grep -n '^data HighlightingLevel'
+type HighlightingLevel string
+
+const (
+	HighlightingNone           HighlightingLevel = "None"
+	HighlightingNonInteractive HighlightingLevel = "NonInteractive"
+	HighlightingInteractive    HighlightingLevel = "Interactive"
+)
+
+// find $AGDA_SRCDIR -type f | xargs grep -n '^data HighlightingMethod'
+type HighlightingMethod string
+
+const (
+	HighlightingDirect   HighlightingMethod = "Direct"
+	HighlightingIndirect HighlightingMethod = "Indirect"
+)
+
+// find $AGDA_SRCDIR -type f | xargs grep -n '^data UseForce'
+type UseForce string
+
+const (
+	WithForce    UseForce = "WithForce"
+	WithoutForce UseForce = "WithoutForce"
+)
+
+type Command interface {
+	isCommand()
+}
+
+// Embedded in every command struct.
+type command struct{}
+
+func (command) isCommand() {}
+
+type Cmd_load struct {
+	command
+	File    string
+	Options []string
+}
+
+type Cmd_constraints struct{ command }
+
+type Cmd_metas struct{ command }
+
+type Cmd_show_module_contents_toplevel struct {
+	command
+	Rewrite Rewrite
+	Module  string
+}
+
+type Cmd_search_about_toplevel struct {
+	command
+	Rewrite Rewrite
+	Search  string
+}
+
+type Cmd_solveAll struct {
+	command
+	Rewrite Rewrite
+}
+
+type Cmd_solveOne struct {
+	command
+	Rewrite Rewrite
+	Goal    int
+	Range   AgdaRange
+	Expr    string
+}
+
+type Cmd_autoOne struct {
+	command
+	Goal  int
+	Range AgdaRange
+	Hints string
+}
+
+type Cmd_autoAll struct{ command }
+
+type Cmd_infer_toplevel struct {
+	command
+	Rewrite Rewrite
+	Expr    string
+}
+
+type Cmd_compute_toplevel struct {
+	command
+	ComputeMode ComputeMode
+	Expr        string
+}
+
+type Cmd_load_highlighting_info struct {
+	command
+	File string
+}
+
+type ShowImplicitArgs struct {
+	command
+	Show bool
+}
+
+type ToggleImplicitArgs struct{ command }
+
+// Since agda 2.6.2
+type ShowIrrelevantArgs struct {
+	command
+	Show bool
+}
+
+// Since agda 2.6.2
+type ToggleIrrelevantArgs struct{ command }
+
+type Cmd_give struct {
+	command
+	Force UseForce
+	Goal  int
+	Range AgdaRange
+	Expr  string
+}
+
+type Cmd_refine struct {
+	command
+	Goal  int
+	Range AgdaRange
+	Expr  string
+}
+
+type Cmd_intro struct {
+	command
+	PreferRecordConstructor bool
+	Goal                    int
+	Range                   AgdaRange
+	Expr                    string
+}
+
+type Cmd_refine_or_intro struct {
+	command
+	PreferRecordConstructor bool
+	Goal                    int
+	Range                   AgdaRange
+	Expr                    string
+}
+
+type Cmd_context struct {
+	command
+	Rewrite Rewrite
+	Goal    int
+	Range   AgdaRange
+	Expr    string
+}
+
+type Cmd_helper_function struct {
+	command
+	Rewrite Rewrite
+	Goal    int
+	Range   AgdaRange
+	Expr    string
+}
+
+type Cmd_infer struct {
+	command
+	Rewrite Rewrite
+	Goal    int
+	Range   AgdaRange
+	Expr    string
+}
+
+type Cmd_goal_type struct {
+	command
+	Rewrite Rewrite
+	Goal    int
+	Range   AgdaRange
+	Expr    string
+}
+
+type Cmd_elaborate_give struct {
+	command
+	Rewrite Rewrite
+	Goal    int
+	Range   AgdaRange
+	Expr    string
+}
+
+type Cmd_goal_type_context struct {
+	command
+	Rewrite Rewrite
+	Goal    int
+	Range   AgdaRange
+	Expr    string
+}
+
+type Cmd_goal_type_context_infer struct {
+	command
+	Rewrite Rewrite
+	Goal    int
+	Range   AgdaRange
+	Expr    string
+}
+
+type Cmd_goal_type_context_check struct {
+	command
+	Rewrite Rewrite
+	Goal    int
+	Range   AgdaRange
+	Expr    string
+}
+
+type Cmd_show_module_contents struct {
+	command
+	Rewrite Rewrite
+	Goal    int
+	Range   AgdaRange
+	Module  string
+}
+
+type Cmd_make_case struct {
+	command
+	Goal  int
+	Range AgdaRange
+	Expr  string
+}
+
+type Cmd_compute struct {
+	command
+	ComputeMode ComputeMode
+	Goal        int
+	Range       AgdaRange
+	Expr        string
+}
+
+type Cmd_why_in_scope struct {
+	command
+	Goal  int
+	Range AgdaRange
+	Name  string
+}
+
+type Cmd_why_in_scope_toplevel struct {
+	command
+	Name string
+}
+
+type Cmd_show_version struct{ command }
+
+type Cmd_abort struct{ command }
+
+type Cmd_exit struct{ command }
+
+// Returns the IOTCM in the syntax agda reads, without the trailing new line.
+// Empty enumerations are sent as the constructors in defaultArguments.
+func (iotcm IOTCM) Encode() (string, error) {
+	level, method := iotcm.Level, iotcm.Method
+	if level == "" {
+		level = HighlightingNonInteractive
+	}
+	if method == "" {
+		method = HighlightingDirect
+	}
+	cmd, err := encodeCommand(iotcm.File, iotcm.Command)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("IOTCM %s %s %s (%s)", haskellString(iotcm.File), level, method, cmd), nil
+}
+
+// Returns the encoded IOTCM, or a description of the error, for logging.
+func (iotcm IOTCM) String() string {
+	line, err := iotcm.Encode()
+	if err != nil {
+		return fmt.Sprintf("IOTCM %s: %s", haskellString(iotcm.File), err)
+	}
+	return line
+}
+
+// Constructors sent for enumerations left empty.
+var defaultArguments = map[reflect.Type]string{
+	reflect.TypeOf(Rewrite("")):     "Simplified",
+	reflect.TypeOf(ComputeMode("")): "DefaultCompute",
+	reflect.TypeOf(UseForce("")):    string(WithoutForce),
+}
+
+// Encodes the constructor name of cmd followed by its arguments.
+// Ranges refer to file.
+func encodeCommand(file string, cmd Command) (string, error) {
+	if cmd == nil {
+		return "", fmt.Errorf("no command")
+	}
+	value := reflect.ValueOf(cmd)
+	if value.Kind() != reflect.Struct {
+		return "", fmt.Errorf("cannot encode command of type %s", value.Type())
+	}
+	words := []string{value.Type().Name()}
+	for i := 0; i < value.NumField(); i++ {
+		if value.Type().Field(i).PkgPath != "" { // unexported, e.g. command
+			continue
+		}
+		word, err := encodeArgument(file, value.Field(i))
+		if err != nil {
+			return "", fmt.Errorf("%s.%s: %w", value.Type().Name(), value.Type().Field(i).Name, err)
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " "), nil
+}
+
+func encodeArgument(file string, value reflect.Value) (string, error) {
+	switch arg := value.Interface().(type) {
+	case AgdaRange:
+		return haskellRange(file, arg), nil
+	case string:
+		return haskellString(arg), nil
+	case []string:
+		return haskellStrings(arg), nil
+	case bool:
+		if arg {
+			return "True", nil
+		}
+		return "False", nil
+	}
+	switch value.Kind() {
+	case reflect.String: // enumerations like Rewrite
+		if value.String() == "" {
+			if constructor, ok := defaultArguments[value.Type()]; ok {
+				return constructor, nil
+			}
+			return "", fmt.Errorf("empty %s", value.Type())
+		}
+		return value.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(value.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(value.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("cannot encode argument of type %s", value.Type())
+}
+
+// Reports whether cmd refers to the loaded file, e.g. by goal indices.
+func needsLoadedFile(cmd Command) bool {
+	switch cmd.(type) {
+	case Cmd_load, Cmd_show_version, Cmd_abort, Cmd_exit:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package agda
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// One command of every type, in the order of commands.go.
+var goldenCommands = func() []Command {
+	r := AgdaRange{{Start: Position{Pos: 40, Line: 4, Col: 7}, End: Position{Pos: 47, Line: 4, Col: 14}}}
+	return []Command{
+		Cmd_load{File: "/tmp/Nat.agda", Options: []string{"--safe", "-i", "src"}},
+		Cmd_constraints{},
+		Cmd_metas{},
+		Cmd_show_module_contents_toplevel{Rewrite: "Normalised", Module: "Data.Nat"},
+		Cmd_search_about_toplevel{Rewrite: "AsIs", Search: "_+_"},
+		Cmd_solveAll{Rewrite: "Instantiated"},
+		Cmd_solveOne{Rewrite: "HeadNormal", Goal: 1, Range: r, Expr: "n"},
+		Cmd_autoOne{Goal: 2, Hints: "-m"},
+		Cmd_autoAll{},
+		Cmd_infer_toplevel{Rewrite: "Simplified", Expr: "suc zero"},
+		Cmd_compute_toplevel{ComputeMode: "UseShowInstance", Expr: "show 1"},
+		Cmd_load_highlighting_info{File: "/tmp/Nat.agda"},
+		ShowImplicitArgs{Show: true},
+		ToggleImplicitArgs{},
+		ShowIrrelevantArgs{Show: false},
+		ToggleIrrelevantArgs{},
+		Cmd_give{Force: WithForce, Goal: 0, Range: r, Expr: "suc n"},
+		Cmd_refine{Goal: 0, Expr: "suc"},
+		Cmd_intro{PreferRecordConstructor: true, Goal: 0, Expr: ""},
+		Cmd_refine_or_intro{Goal: 0, Expr: "λ x → x"},
+		Cmd_context{Rewrite: "Normalised", Goal: 3},
+		Cmd_helper_function{Rewrite: "AsIs", Goal: 3, Expr: "h n"},
+		Cmd_infer{Rewrite: "Simplified", Goal: 3, Expr: "n"},
+		Cmd_goal_type{Rewrite: "Simplified", Goal: 3},
+		Cmd_elaborate_give{Rewrite: "Normalised", Goal: 3, Expr: "\"quoted\""},
+		Cmd_goal_type_context{Rewrite: "Simplified", Goal: 3},
+		Cmd_goal_type_context_infer{Rewrite: "Simplified", Goal: 3, Expr: "n"},
+		Cmd_goal_type_context_check{Rewrite: "Simplified", Goal: 3, Expr: "n"},
+		Cmd_show_module_contents{Rewrite: "Simplified", Goal: 3, Module: "M"},
+		Cmd_make_case{Goal: 4, Range: r, Expr: "m n"},
+		Cmd_compute{ComputeMode: "IgnoreAbstract", Goal: 4, Expr: "1 + 1"},
+		Cmd_why_in_scope{Goal: 4, Name: "zero"},
+		Cmd_why_in_scope_toplevel{Name: "suc"},
+		Cmd_show_version{},
+		Cmd_abort{},
+		Cmd_exit{},
+		// Empty enumerations are sent as their defaults.
+		Cmd_infer_toplevel{Expr: "zero"},
+		Cmd_compute_toplevel{Expr: "zero"},
+		Cmd_give{Goal: 0, Expr: "zero"},
+	}
+}()
+
+// Compares the encoded commands to testdata/commands.golden. Run
+// go test -update to rewrite the golden file.
+func TestEncodeCommandGolden(t *testing.T) {
+	var lines []string
+	for _, cmd := range goldenCommands {
+		iotcm := IOTCM{File: "/tmp/Nat.agda", Level: HighlightingNonInteractive, Method: HighlightingDirect, Command: cmd}
+		line, err := iotcm.Encode()
+		if err != nil {
+			t.Fatalf("cannot encode %T: %s", cmd, err)
+		}
+		lines = append(lines, line)
+	}
+	got := strings.Join(lines, "\n") + "\n"
+	const goldenPath = "testdata/commands.golden"
+	if *update {
+		if err := ioutil.WriteFile(goldenPath, []byte(got), 0666); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("encoded commands differ from %s:\n%s", goldenPath, got)
+	}
+}
+
+type cmdUnsupported struct {
+	command
+	Ratio float64
+}
+
+func TestEncodeCommandErrors(t *testing.T) {
+	if _, err := (IOTCM{File: "/tmp/Nat.agda", Command: cmdUnsupported{Ratio: 0.5}}).Encode(); err == nil {
+		t.Error("encoded a float argument")
+	}
+	if _, err := (IOTCM{File: "/tmp/Nat.agda"}).Encode(); err == nil {
+		t.Error("encoded a missing command")
+	}
+	line, err := IOTCM{File: "/tmp/Nat.agda", Command: Cmd_metas{}}.Encode()
+	if want := `IOTCM "/tmp/Nat.agda" NonInteractive Direct (Cmd_metas)`; err != nil || line != want {
+		t.Errorf("encoded %q, %v, want %q", line, err, want)
+	}
+}
@@ -0,0 +1,98 @@
+package agda
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Set to the script a test binary runs as agda, see fakeAgda.
+const fakeAgdaScript = "FAKE_AGDA_SCRIPT"
+
+func TestMain(m *testing.M) {
+	if script := os.Getenv(fakeAgdaScript); script != "" {
+		os.Exit(fakeAgda(script, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+	}
+	os.Exit(m.Run())
+}
+
+// Returns the path of an agda executable which plays the script in
+// testdata/name.transcript. The script is a transcript, see Replay:
+// the lines prefixed by "> " are the commands the fake expects, in order,
+// the lines prefixed by "< " are written in reply.
+func fakeAgdaPath(t *testing.T, name string) string {
+	t.Helper()
+	agdaPath, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Setenv(fakeAgdaScript, "testdata/"+name+".transcript"); err != nil {
+		t.Fatal(err)
+	}
+	return agdaPath
+}
+
+// Behaves like agda following script. Reports unexpected commands on
+// stderr and fails, so they end the session with Resp_Exited.
+func fakeAgda(script string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	file, err := os.Open(script)
+	if err != nil {
+		fmt.Fprintf(stderr, "fake agda: %s\n", err)
+		return 1
+	}
+	defer file.Close()
+	lines := bufio.NewScanner(file)
+	if !lines.Scan() {
+		fmt.Fprintf(stderr, "fake agda: empty script %s\n", script)
+		return 1
+	}
+	version, err := ParseVersion(lines.Text())
+	if err != nil {
+		fmt.Fprintf(stderr, "fake agda: %s\n", err)
+		return 1
+	}
+	if len(args) == 1 && args[0] == "--version" {
+		fmt.Fprintf(stdout, "Agda version %s\n", version)
+		return 0
+	}
+	if len(args) == 0 || args[0] != "--interaction-json" {
+		fmt.Fprintf(stderr, "fake agda: unexpected arguments %q\n", args)
+		return 1
+	}
+	commands := bufio.NewReader(stdin)
+	for lines.Scan() {
+		line := lines.Text()
+		switch {
+		case strings.HasPrefix(line, "> "):
+			cmd, err := commands.ReadString('\n')
+			if err != nil {
+				fmt.Fprintf(stderr, "fake agda: expected %s, got %v\n", line[2:], err)
+				return 1
+			}
+			if cmd = strings.TrimSuffix(cmd, "\n"); cmd != line[2:] {
+				fmt.Fprintf(stderr, "fake agda: expected\n%s\ngot\n%s\n", line[2:], cmd)
+				return 1
+			}
+		case line == "< "+prompt:
+			fmt.Fprint(stdout, prompt)
+		case strings.HasPrefix(line, "< "):
+			fmt.Fprintln(stdout, line[2:])
+		}
+	}
+	for {
+		cmd, err := commands.ReadString('\n')
+		if err == io.EOF {
+			return 0
+		} else if err != nil {
+			fmt.Fprintf(stderr, "fake agda: %s\n", err)
+			return 1
+		}
+		if !strings.Contains(cmd, "Cmd_exit") {
+			fmt.Fprintf(stderr, "fake agda: unexpected command after the script\n%s", cmd)
+			return 1
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Encodes the arguments of IOTCM commands. Agda parses the commands with
+// Haskell's Read class, so the values are written as Haskell literals.
+package agda
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Returns s as Haskell string literal. Only printable ASCII is written
+// as is, all other characters are escaped, like Haskell's show does.
+func haskellString(s string) string {
+	var builder strings.Builder
+	builder.WriteByte('"')
+	runes := []rune(s)
+	for i, r := range runes {
+		switch {
+		case r == '"':
+			builder.WriteString(`\"`)
+		case r == '\\':
+			builder.WriteString(`\\`)
+		case r == '\n':
+			builder.WriteString(`\n`)
+		case r == '\t':
+			builder.WriteString(`\t`)
+		case r < 0x20 || r >= 0x7f:
+			builder.WriteString(`\` + strconv.Itoa(int(r)))
+			if i+1 < len(runes) && '0' <= runes[i+1] && runes[i+1] <= '9' {
+				builder.WriteString(`\&`) // separates the escape from a following digit
+			}
+		default:
+			builder.WriteRune(r)
+		}
+	}
+	builder.WriteByte('"')
+	return builder.String()
+}
+
+// Returns the Haskell list of the already encoded elements.
+func haskellList(elems []string) string {
+	return "[" + strings.Join(elems, ",") + "]"
+}
+
+// Returns s as Haskell string literal, for IOTCM lines written by hand.
+func Quote(s string) string {
+	return haskellString(s)
+}
+
+func haskellStrings(strs []string) string {
+	elems := make([]string, len(strs))
+	for i, s := range strs {
+		elems[i] = haskellString(s)
+	}
+	return haskellList(elems)
+}
+
+// Returns Just elem, or Nothing if elem is empty.
+func haskellMaybe(elem string) string {
+	if elem == "" {
+		return "Nothing"
+	}
+	return "(Just " + elem + ")"
+}
+
+// Returns the Range of the intervals in file, or noRange if there are none.
+func haskellRange(file string, intervals AgdaRange) string {
+	if len(intervals) == 0 {
+		return "noRange"
+	}
+	elems := make([]string, len(intervals))
+	for i, interval := range intervals {
+		elems[i] = fmt.Sprintf("Interval %s %s", haskellPosition(interval.Start), haskellPosition(interval.End))
+	}
+	return fmt.Sprintf("(intervalsToRange %s %s)", haskellMaybe("(mkAbsolute "+haskellString(file)+")"), haskellList(elems))
+}
+
+func haskellPosition(pos Position) string {
+	return fmt.Sprintf("(Pn () %d %d %d)", pos.Pos, pos.Line, pos.Col)
+}
@@ -0,0 +1,26 @@
+package agda
+
+import "testing"
+
+func TestHaskellString(t *testing.T) {
+	for _, test := range []struct {
+		s, want string
+	}{
+		{"", `""`},
+		{"suc n", `"suc n"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`a\b`, `"a\\b"`},
+		{"a\nb\tc", `"a\nb\tc"`},
+		{"\x01", `"\1"`},
+		{"\x7f", `"\127"`},
+		{"ℕ → ℕ", `"\8469 \8594 \8469"`},
+		{"È" + "1", `"\200\&1"`},
+		{"È" + "x", `"\200x"`},
+		{"\x00" + "0", `"\0\&0"`},
+		{"\n1", `"\n1"`},
+	} {
+		if got := haskellString(test.s); got != test.want {
+			t.Errorf("haskellString(%q) is %s, want %s", test.s, got, test.want)
+		}
+	}
+}
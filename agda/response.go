@@ -1,98 +1,26 @@
-package main
+package agda
 
 import (
-	"bufio"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"log"
-	"os/exec"
+	"os"
 	"strings"
 )
 
-const prompt = "JSON> "
-
-type Agda struct {
-	filename  string
-	stdout    io.ReadCloser
-	stderr    io.ReadCloser
-	stdin     io.WriteCloser
-	responses <-chan Response
-}
-
-func NewAgda(agdaCmdPath, filename string) (*Agda, error) {
-	agdaCmd := exec.Command(agdaCmdPath, "--interaction-json")
-	stdin, err := agdaCmd.StdinPipe()
-	if err != nil {
-		return nil, err
-	}
-	stdout, err := agdaCmd.StdoutPipe()
-	if err != nil {
-		return nil, err
-	}
-	stderr, err := agdaCmd.StderrPipe()
-	if err != nil {
-		return nil, err
-	}
-	if err := agdaCmd.Start(); err != nil {
-		return nil, err
-	}
-	responses := make(chan Response)
-	go func(res chan<- Response) {
-		reader := bufio.NewReader(stdout)
-		for {
-			if line, err := reader.ReadString('\n'); err != nil {
-				log.Printf("error reading agda output line: %s", err)
-			} else {
-				// drop the prompt
-				if response, err := parseResponse(strings.TrimPrefix(line, prompt)); err != nil {
-					log.Printf("error parsing response: %s", err)
-				} else {
-					res <- response
-				}
-			}
-		}
-	}(responses)
-	return &Agda{filename: filename, stdin: stdin, stdout: stdout, stderr: stderr, responses: responses}, nil
-}
-
-func (a *Agda) Responses() <-chan Response {
-	return a.responses
-}
-
-func (a *Agda) writeCommand(cmd string) error {
-	cmdString := fmt.Sprintf(`IOTCM "%s" None Direct (%s)
-`, a.filename, cmd) // The new line is important
-	debugPrint("sending command: %s", cmdString)
-	_, err := io.WriteString(a.stdin, cmdString)
-	return err
-}
-func (a *Agda) LoadFile(args ...string) error {
-	return a.writeCommand(fmt.Sprintf(`Cmd_load "%s" [%s]`, a.filename, strings.Join(args, ",")))
-}
-
-func (a *Agda) CaseSplit(goalIdx int, varName string) error {
-	return a.writeCommand(fmt.Sprintf(`Cmd_make_case %d noAgdaRange "%s"`, goalIdx, varName))
-}
-
-func (a *Agda) RefineHole(goalIdx int, content string) error {
-	return a.writeCommand(fmt.Sprintf(`Cmd_refine %d noAgdaRange "%s"`, goalIdx, content))
-}
-
-func (*Agda) Kill() {
-}
-
-func parseResponse(response string) (Response, error) {
-	debugPrint("parsing: %s", response)
+// Decodes a response of agda version.
+func parseResponse(response string, version Version) (Response, error) {
+	Debugf("parsing: %s", response)
 	var unkownResp map[string]interface{}
 	if err := json.Unmarshal([]byte(response), &unkownResp); err != nil {
 		return nil, err
 	} else {
-		debugPrint("parsing intermediate map: %v", unkownResp)
+		Debugf("parsing intermediate map: %v", unkownResp)
 		switch unkownResp["kind"] {
 		case "DisplayInfo":
-			if info, err := parseDisplayInfo(unkownResp["info"]); err != nil {
+			if info, err := parseDisplayInfo(unkownResp["info"], version); err != nil {
 				return nil, err
 			} else {
 				return Resp_DisplayInfo{Info: info}, nil
@@ -147,6 +75,13 @@ func parseResponse(response string) (Response, error) {
 			} else {
 				return resp, nil
 			}
+		case "HighlightingInfo":
+			var resp Resp_HighlightingInfo
+			if err := json.Unmarshal([]byte(response), &resp); err != nil {
+				return nil, err
+			} else {
+				return resp, nil
+			}
 		case "SolveAll":
 			var resp Resp_SolveAll
 			if err := json.Unmarshal([]byte(response), &resp); err != nil {
@@ -159,19 +94,156 @@ func parseResponse(response string) (Response, error) {
 	}
 }
 
-func parseDisplayInfo(thing interface{}) (DisplayInfo, error) {
+// Reads and removes the file of an indirect Resp_HighlightingInfo.
+func ReadHighlightingFile(path string) (HighlightingInfo, error) {
+	var info HighlightingInfo
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+	if err := os.Remove(path); err != nil {
+		log.Printf("could not remove highlighting file: %s", err)
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+func parseDisplayInfo(thing interface{}, version Version) (DisplayInfo, error) {
 	if infoMap, ok := thing.(map[string]interface{}); !ok {
 		return nil, errors.New("DisplayInfo should be an (JSON) object")
 	} else {
 		switch infoMap["kind"] {
 		case "CompilationOk":
-			return Info_CompilationOk{Warnings: infoMap["warnings"].(string), Errors: infoMap["errors"].(string)}, nil
+			warnings, ok := messages(infoMap["warnings"])
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("malformed CompilationOk warnings: %v", thing))
+			}
+			errs, ok := messages(infoMap["errors"])
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("malformed CompilationOk errors: %v", thing))
+			}
+			return Info_CompilationOk{Warnings: warnings, Errors: errs}, nil
+		case "AllGoalsWarnings":
+			warnings, ok := messages(infoMap["warnings"])
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("malformed AllGoalsWarnings warnings: %v", thing))
+			}
+			errs, ok := messages(infoMap["errors"])
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("malformed AllGoalsWarnings errors: %v", thing))
+			}
+			var goals struct {
+				VisibleGoals   []OutputConstraint
+				InvisibleGoals []OutputConstraint
+			}
+			if data, err := json.Marshal(infoMap); err != nil {
+				return nil, err
+			} else if err := json.Unmarshal(data, &goals); err != nil {
+				return nil, err
+			}
+			return Info_AllGoalsWarnings{
+				Warnings:       warnings,
+				Errors:         errs,
+				VisibleGoals:   goals.VisibleGoals,
+				InvisibleGoals: goals.InvisibleGoals,
+			}, nil
+		case "GoalSpecific":
+			var info struct {
+				InteractionPoint InteractionId
+				GoalInfo         json.RawMessage
+			}
+			if data, err := json.Marshal(infoMap); err != nil {
+				return nil, err
+			} else if err := json.Unmarshal(data, &info); err != nil {
+				return nil, err
+			} else if goalInfo, err := parseGoalDisplayInfo(info.GoalInfo); err != nil {
+				return nil, err
+			} else {
+				return Info_GoalSpecific{InteractionPoint: info.InteractionPoint, GoalInfo: goalInfo}, nil
+			}
+		case "Error":
+			if version.Before(Version{2, 6, 2}) {
+				if message, ok := infoMap["message"].(string); ok {
+					return Info_Error{Message: message}, nil
+				}
+			} else if err, ok := infoMap["error"].(map[string]interface{}); ok {
+				if message, ok := err["message"].(string); ok {
+					return Info_Error{Message: message}, nil
+				}
+			}
+			return nil, errors.New(fmt.Sprintf("malformed Error for agda %s: %v", version, thing))
+		case "InferredType":
+			if expr, ok := infoMap["expr"].(string); ok {
+				return Info_InferredType{Expr: expr}, nil
+			}
+			return nil, errors.New(fmt.Sprintf("malformed InferredType: %v", thing))
+		case "WhyInScope":
+			var info Info_WhyInScope
+			if data, err := json.Marshal(infoMap); err != nil {
+				return nil, err
+			} else if err := json.Unmarshal(data, &info); err != nil {
+				return nil, err
+			}
+			return info, nil
+		case "Version":
+			if v, ok := infoMap["version"].(string); ok {
+				return Info_Version{Version: v}, nil
+			}
+			return nil, errors.New(fmt.Sprintf("malformed Version: %v", thing))
 		default:
 			return nil, errors.New(fmt.Sprintf("unknown DiplayInfo %v", thing))
 		}
 	}
 }
 
+// Returns warnings or errors as text. Before agda 2.6.2 they are sent as
+// text, later as a list of objects with a message.
+func messages(thing interface{}) (string, bool) {
+	switch thing := thing.(type) {
+	case string:
+		return thing, true
+	case []interface{}:
+		var texts []string
+		for _, item := range thing {
+			if object, ok := item.(map[string]interface{}); !ok {
+				return "", false
+			} else if message, ok := object["message"].(string); !ok {
+				return "", false
+			} else {
+				texts = append(texts, message)
+			}
+		}
+		return strings.Join(texts, "\n"), true
+	default:
+		return "", false
+	}
+}
+
+func parseGoalDisplayInfo(data json.RawMessage) (GoalDisplayInfo, error) {
+	var kind struct{ Kind string }
+	if err := json.Unmarshal(data, &kind); err != nil {
+		return nil, err
+	}
+	switch kind.Kind {
+	case "GoalType":
+		var info Goal_GoalType
+		if err := json.Unmarshal(data, &info); err != nil {
+			return nil, err
+		} else {
+			return info, nil
+		}
+	case "InferredType":
+		var info Goal_InferredType
+		if err := json.Unmarshal(data, &info); err != nil {
+			return nil, err
+		} else {
+			return info, nil
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown GoalDisplayInfo %s", data))
+	}
+}
+
 type Status struct {
 	// Are implicit arguments displayed
 	ShowImplicitArguments bool
@@ -225,7 +297,7 @@ type ProblemId uint
 // find $AGDA_SRCDIR -type f | xargs grep -n '^newtype InteractionId'
 // find $AGDA_SRCDIR -type f | xargs grep -n '^instance EncodeTCM InteractionId'
 type InteractionId struct {
-	Id        uint
+	Id    uint
 	Range AgdaRange
 }
 
@@ -260,11 +332,32 @@ type OutputForm struct {
 	Constraint OutputConstraint
 }
 
+// find $AGDA_SRCDIR -type f | xargs grep -n '^data OutputConstraint'
+// find $AGDA_SRCDIR -type f | xargs grep -n 'encodeOC'
 type OutputConstraint struct {
+	Kind string
+	// The goal, an InteractionId for visible goals, a NamedMeta for others
+	ConstraintObj  ConstraintObj
+	Type           string
 	Comparison     string
 	ConstraintObjs interface{}
 }
 
+type ConstraintObj struct {
+	Id    uint
+	Name  string
+	Range AgdaRange
+}
+
+// Returns the name of the goal, ?0 for the visible goal 0, or the name of
+// an invisible goal like _5.
+func (obj ConstraintObj) String() string {
+	if obj.Name != "" {
+		return obj.Name
+	}
+	return fmt.Sprintf("?%d", obj.Id)
+}
+
 // find $AGDA_SRCDIR -type f | xargs grep -n '^data DisplayInfo'
 // find $AGDA_SRCDIR -type f | xargs grep -n '^instance EncodeTCM DisplayInfo'
 type DisplayInfo interface{}
@@ -390,6 +483,8 @@ type Goal_GoalType struct {
 	Type        string
 	Boundary    []string
 	OutputForms []string
+	// The context of the goal
+	Entries []ResponseContextEntry
 }
 
 type Goal_CurrentGoal struct {
@@ -403,7 +498,36 @@ type Goal_InferredType struct {
 
 type Response interface{}
 
-type Resp_HighlightingInfo struct{}
+// find $AGDA_SRCDIR -type f | xargs grep -n '^instance EncodeTCM HighlightingInfo'
+type Resp_HighlightingInfo struct {
+	Direct bool
+	// Set if Direct
+	Info HighlightingInfo
+	// File containing the HighlightingInfo unless Direct
+	Filepath string
+}
+
+type HighlightingInfo struct {
+	// Remove the highlighting of the ranges in Payload before adding the new one
+	Remove  bool
+	Payload []HighlightingToken
+}
+
+// find $AGDA_SRCDIR -type f | xargs grep -n '^instance EncodeTCM TokenBased'
+type HighlightingToken struct {
+	// Start and end, exclusive, as 1-based character offsets
+	Range          [2]int
+	Atoms          []string
+	TokenBased     string
+	Note           string
+	DefinitionSite *DefinitionSite
+}
+
+type DefinitionSite struct {
+	Filepath string
+	// 1-based character offset
+	Position int
+}
 
 type Resp_DisplayInfo struct {
 	Info DisplayInfo
@@ -428,7 +552,8 @@ type Resp_Status struct {
 
 type Resp_JumpToError struct {
 	Filepath string
-	Position Position
+	// 1-based character offset
+	Position int
 }
 
 type Resp_InteractionPoints struct {
@@ -445,6 +570,22 @@ type Solution struct {
 	Expression       string
 }
 
+// Not sent by agda, but by the client when agda printed its prompt after
+// executing a command. It is the last response to the command.
+type Resp_Prompt struct{}
+
+// Not sent by agda, but by the client when agda exits unexpectedly.
+type Resp_Exited struct {
+	// The end of agda's standard error
+	Stderr string
+}
+
+// Not sent by agda, but by the client when agda reports an internal
+// error on its standard error.
+type Resp_InternalError struct {
+	Stderr string
+}
+
 type Resp_SolveAll struct {
 	Solutions []Solution
 }
@@ -453,4 +594,4 @@ type Resp_MakeCase struct {
 	InteractionPoint InteractionId
 	Variant          string
 	Clauses          []string
-}
\ No newline at end of file
+}
@@ -0,0 +1,146 @@
+package agda
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/responses")
+
+// The releases of agda from MinVersion to MaxVersion
+var releases = []Version{{2, 6, 1}, {2, 6, 2}, {2, 6, 3}, {2, 6, 4}}
+
+// Releases without responses of their own in testdata/responses, which
+// are decoded with those of a release encoding them alike
+var sameResponses = map[Version]Version{
+	{2, 6, 2}: {2, 6, 4},
+	{2, 6, 3}: {2, 6, 4},
+}
+
+// Decodes the responses in testdata/responses/VERSION/NAME.json as every
+// release they stand for and compares them to NAME.golden. Run go test
+// -update to rewrite the golden files. See testdata/responses/README for
+// where the responses come from.
+func TestParseResponseCorpus(t *testing.T) {
+	if releases[0] != MinVersion || releases[len(releases)-1] != MaxVersion {
+		t.Fatalf("releases %v do not span agda %s to %s", releases, MinVersion, MaxVersion)
+	}
+	dirs, err := filepath.Glob("testdata/responses/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	corpus := make(map[Version]string)
+	for _, dir := range dirs {
+		if version, err := ParseVersion(filepath.Base(dir)); err == nil {
+			corpus[version] = dir
+		}
+	}
+	versions := append([]Version{}, releases...)
+	for version := range corpus {
+		if version.Before(MinVersion) || MaxVersion.Before(version) {
+			versions = append(versions, version)
+		}
+	}
+	for _, version := range versions {
+		dir, ok := corpus[version]
+		if !ok {
+			dir = corpus[sameResponses[version]]
+		}
+		responses, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dir == "" || len(responses) == 0 {
+			t.Errorf("no responses of agda %s in testdata/responses", version)
+		}
+		for _, path := range responses {
+			testCorpusResponse(t, path, version)
+		}
+	}
+}
+
+// Decodes the response in path as agda version does and compares it to
+// the golden file.
+func testCorpusResponse(t *testing.T, path string, version Version) {
+	t.Helper()
+	response, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := decoded(parseResponse(strings.TrimSpace(string(response)), version))
+	goldenPath := strings.TrimSuffix(path, ".json") + ".golden"
+	if *update {
+		if err := ioutil.WriteFile(goldenPath, []byte(got), 0666); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s decoded as agda %s to\n%s\nwant\n%s", path, version, got, want)
+	}
+}
+
+// Returns the type and JSON encoding of a decoded response, or the error.
+func decoded(response Response, err error) string {
+	if err != nil {
+		return fmt.Sprintf("error: %s\n", err)
+	}
+	data, err := json.MarshalIndent(response, "", "\t")
+	if err != nil {
+		return fmt.Sprintf("cannot encode: %s\n", err)
+	}
+	return fmt.Sprintf("%s\n%s\n", typeName(response), data)
+}
+
+// Returns the type of response, including the kind of information displayed.
+func typeName(response Response) string {
+	switch response := response.(type) {
+	case Resp_DisplayInfo:
+		if goal, ok := response.Info.(Info_GoalSpecific); ok {
+			return fmt.Sprintf("%T %T %T", response, goal, goal.GoalInfo)
+		}
+		return fmt.Sprintf("%T %T", response, response.Info)
+	default:
+		return fmt.Sprintf("%T", response)
+	}
+}
+
+func TestParseMalformedResponses(t *testing.T) {
+	tests := []struct {
+		version  Version
+		response string
+	}{
+		{Version{2, 6, 1}, `{"kind":"DisplayInfo","info":{"kind":"CompilationOk"}}`},
+		{Version{2, 6, 1}, `{"kind":"DisplayInfo","info":{"kind":"CompilationOk","warnings":1,"errors":""}}`},
+		{Version{2, 6, 2}, `{"kind":"DisplayInfo","info":{"kind":"CompilationOk","warnings":[1],"errors":[]}}`},
+		{Version{2, 6, 2}, `{"kind":"DisplayInfo","info":{"kind":"CompilationOk","warnings":[],"errors":[{}]}}`},
+		{Version{2, 6, 1}, `{"kind":"DisplayInfo","info":{"kind":"Error","error":{"message":"new format"}}}`},
+		{Version{2, 6, 2}, `{"kind":"DisplayInfo","info":{"kind":"Error","message":"old format"}}`},
+		{Version{2, 6, 2}, `{"kind":"DisplayInfo","info":{"kind":"Error","error":"message"}}`},
+		{Version{2, 6, 2}, `{"kind":"DisplayInfo","info":{"kind":"Version"}}`},
+		{Version{2, 6, 2}, `{"kind":"DisplayInfo","info":"Version"}`},
+		{Version{2, 6, 2}, `{"kind":"DisplayInfo"}`},
+		{Version{2, 6, 2}, `{"kind":"DisplayInfo","info":{"kind":"GoalSpecific","interactionPoint":0}}`},
+		{Version{2, 6, 2}, `{"kind":"DisplayInfo","info":{"kind":"GoalSpecific","interactionPoint":{"id":0,"range":[]}}}`},
+		{Version{2, 6, 2}, `{"kind":"DisplayInfo","info":{"kind":"GoalSpecific","interactionPoint":{"id":0,"range":[]},"goalInfo":{"kind":"GoalType","type":1}}}`},
+		{Version{2, 6, 2}, `{"kind":"Status","status":"checked"}`},
+		{Version{2, 6, 2}, `{"kind":"MakeCase","clauses":"f zero = ?"}`},
+		{Version{2, 6, 2}, `{"kind":"HighlightingInfo","direct":true,"info":{"payload":[{"range":"1-7"}]}}`},
+		{Version{2, 6, 2}, `["kind","Status"]`},
+		{Version{2, 6, 2}, `{"kind":`},
+	}
+	for _, test := range tests {
+		if response, err := parseResponse(test.response, test.version); err == nil {
+			t.Errorf("%s for agda %s decoded to %#v, want an error", test.response, test.version, response)
+		}
+	}
+}
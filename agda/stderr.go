@@ -0,0 +1,68 @@
+package agda
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Number of lines of agda's standard error kept
+const stderrLines = 200
+
+// Number of bytes kept of a line of agda's standard error
+const stderrLineLength = 4096
+
+// Keeps the last lines written to agda's standard error.
+type lineRing struct {
+	sync.Mutex
+	lines []string
+	// Index of the oldest line once the ring is full
+	next int
+}
+
+func (ring *lineRing) add(line string) {
+	ring.Lock()
+	defer ring.Unlock()
+	if len(ring.lines) < stderrLines {
+		ring.lines = append(ring.lines, line)
+		return
+	}
+	ring.lines[ring.next] = line
+	ring.next = (ring.next + 1) % len(ring.lines)
+}
+
+func (ring *lineRing) String() string {
+	ring.Lock()
+	defer ring.Unlock()
+	lines := append(append([]string{}, ring.lines[ring.next:]...), ring.lines[:ring.next]...)
+	return strings.Join(lines, "\n")
+}
+
+// Reads agda's standard error until it is closed, so agda never blocks
+// on a full pipe. Internal errors are reported to the owner right away.
+// Lines of any length are read, but only their start is kept.
+func (process *agdaProcess) readStderr() {
+	defer close(process.stderrDone)
+	reader := bufio.NewReader(process.stderr)
+	for {
+		line, err := reader.ReadString('\n')
+		if line = strings.TrimRight(line, "\r\n"); line != "" || err == nil {
+			Debugf("agda stderr: %s", line)
+			internalError := strings.Contains(line, "An internal error has occurred")
+			if len(line) > stderrLineLength {
+				line = line[:stderrLineLength] + "..."
+			}
+			process.stderrRing.add(line)
+			if internalError {
+				process.deliver(Resp_InternalError{Stderr: process.stderrRing.String()})
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				Debugf("error reading agda stderr: %s", err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package agda
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestReadStderrLongLines(t *testing.T) {
+	long := strings.Repeat("x", 100*1024)
+	client := newClient("/tmp/Nat.agda", nil, nil)
+	client.responses = make(chan Response, 1)
+	process := &agdaProcess{
+		stderr:     ioutil.NopCloser(strings.NewReader(long + "\nAn internal error has occurred\nafter")),
+		stderrDone: make(chan struct{}),
+		owner:      client,
+	}
+	process.readStderr()
+	select {
+	case r := <-client.responses:
+		if _, ok := r.(Resp_InternalError); !ok {
+			t.Errorf("delivered %T, want Resp_InternalError", r)
+		}
+	default:
+		t.Error("the internal error after a long line was not reported")
+	}
+	lines := strings.Split(process.stderrRing.String(), "\n")
+	if len(lines) != 3 || lines[2] != "after" || len(lines[0]) != stderrLineLength+len("...") {
+		t.Errorf("kept %d lines, the first of %d bytes", len(lines), len(lines[0]))
+	}
+}
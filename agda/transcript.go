@@ -0,0 +1,171 @@
+// Transcripts record a session with agda, to reproduce problems without agda.
+//
+// A transcript starts with the line
+//
+//	# agda VERSION
+//
+// followed by the commands sent, prefixed by "> ", and the lines received,
+// prefixed by "< ", in the order they happened.
+package agda
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+)
+
+type transcript struct {
+	sync.Mutex
+	w io.Writer
+}
+
+func (t *transcript) record(prefix, line string) {
+	t.Lock()
+	defer t.Unlock()
+	if t.w == nil {
+		return
+	}
+	if _, err := fmt.Fprintf(t.w, "%s %s\n", prefix, strings.TrimRight(line, "\n")); err != nil {
+		log.Printf("cannot write transcript: %s", err)
+		t.w = nil
+	}
+}
+
+// Stops recording and closes the transcript if it is an io.Closer.
+func (t *transcript) close() {
+	t.Lock()
+	defer t.Unlock()
+	if closer, ok := t.w.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("cannot close transcript: %s", err)
+		}
+	}
+	t.w = nil
+}
+
+// Records the session with agda to w, from now on. Exit closes w if it
+// is an io.Closer.
+func (a *Client) Record(w io.Writer) error {
+	a.process.transcript.Lock()
+	defer a.process.transcript.Unlock()
+	if _, err := fmt.Fprintf(w, "# agda %s\n", a.process.version); err != nil {
+		return err
+	}
+	a.process.transcript.w = w
+	return nil
+}
+
+// Receives the commands of a replayed session. Writing never blocks, as
+// the client holds the process lock the replay needs to deliver responses,
+// so the commands are queued until the replay reaches them.
+type replayInput struct {
+	sync.Mutex
+	written  *sync.Cond
+	commands []string
+	closed   bool
+}
+
+func newReplayInput() *replayInput {
+	input := &replayInput{}
+	input.written = sync.NewCond(&input.Mutex)
+	return input
+}
+
+func (input *replayInput) Write(p []byte) (int, error) {
+	input.Lock()
+	defer input.Unlock()
+	if input.closed {
+		return 0, io.ErrClosedPipe
+	}
+	input.commands = append(input.commands, string(p))
+	input.written.Signal()
+	return len(p), nil
+}
+
+func (input *replayInput) Close() error {
+	input.Lock()
+	defer input.Unlock()
+	input.closed = true
+	input.written.Broadcast()
+	return nil
+}
+
+// Returns the next command written, waiting for it if necessary. Reports
+// false once the input is closed and all commands are read.
+func (input *replayInput) next() (string, bool) {
+	input.Lock()
+	defer input.Unlock()
+	for len(input.commands) == 0 && !input.closed {
+		input.written.Wait()
+	}
+	if len(input.commands) == 0 {
+		return "", false
+	}
+	cmd := input.commands[0]
+	input.commands = input.commands[1:]
+	return cmd, true
+}
+
+// Returns a client for filename which does not run agda but replays the
+// lines received in a recorded transcript. The lines received after a
+// command are replayed once the client sent its next command, so the
+// responses meet the frontend in the state they met it when recorded.
+func Replay(r io.Reader, filename string) (*Client, error) {
+	reader := bufio.NewReader(r)
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("cannot read transcript: %w", err)
+	}
+	if !strings.HasPrefix(header, "# agda ") {
+		return nil, errors.New("transcript does not start with the agda version")
+	}
+	version, err := ParseVersion(header)
+	if err != nil {
+		return nil, err
+	}
+	input := newReplayInput()
+	process := &agdaProcess{
+		version:            version,
+		stdin:              input,
+		eof:                make(chan struct{}),
+		stderrDone:         make(chan struct{}),
+		highlightingMethod: HighlightingDirect,
+	}
+	close(process.stderrDone)
+	a := newClient(filename, nil, process)
+	process.owner, process.first = a, a
+	go process.replay(reader, input)
+	return a, nil
+}
+
+func (process *agdaProcess) replay(reader *bufio.Reader, input *replayInput) {
+	defer close(process.eof)
+	for {
+		line, err := reader.ReadString('\n')
+		switch {
+		case strings.HasPrefix(line, "> "):
+			cmd, ok := input.next()
+			if !ok {
+				return
+			}
+			if strings.TrimSpace(cmd) != strings.TrimSpace(line[2:]) {
+				Debugf("replay: recorded command %s differs from %s", line[2:], cmd)
+			}
+		case strings.HasPrefix(line, "< "):
+			process.handleLine(line[2:])
+		case strings.TrimSpace(line) != "":
+			log.Printf("replay: malformed transcript line %q", line)
+		}
+		if err == io.EOF {
+			Debugf("replay: end of transcript")
+			return
+		} else if err != nil {
+			log.Printf("replay: cannot read transcript: %s", err)
+			return
+		}
+	}
+}
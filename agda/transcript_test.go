@@ -0,0 +1,90 @@
+package agda
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A transcript recorder reporting whether it was closed.
+type closingBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closingBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestReplayManyCommands(t *testing.T) {
+	const n = 200
+	transcript := "# agda 2.6.2\n< JSON> \n" + strings.Repeat("> show_version\n< JSON> \n", n)
+	a, err := Replay(strings.NewReader(transcript), "/tmp/Nat.agda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorded closingBuffer
+	if err := a.Record(&recorded); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := a.Send(Cmd_show_version{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < n; i++ {
+		if r := <-a.Responses(); r != (Resp_Prompt{}) {
+			t.Fatalf("response %d is %T, want a prompt", i, r)
+		}
+	}
+	if err := a.Exit(); err != nil {
+		t.Fatal(err)
+	}
+	if !recorded.closed {
+		t.Error("Exit did not close the transcript")
+	}
+	if sent := strings.Count(recorded.String(), "(Cmd_show_version)"); sent != n {
+		t.Errorf("recorded %d commands, want %d", sent, n)
+	}
+}
+
+// Responses agda sends after a request was answered must not block the
+// session once the client of the request is closed.
+func TestClosedClient(t *testing.T) {
+	const transcript = `# agda 2.6.2
+< JSON> 
+> show_version
+< JSON> 
+< {"kind":"RunningInfo","debugLevel":1,"message":"Checking Nat"}
+> show_version
+< JSON> 
+`
+	a, err := Replay(strings.NewReader(transcript), "/tmp/Nat.agda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := a.Open("/tmp/Nat.agda")
+	if err := request.Send(Cmd_show_version{}); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-request.Responses(); r != (Resp_Prompt{}) {
+		t.Fatalf("request received %T, want a prompt", r)
+	}
+	request.Close()
+	if err := a.Send(Cmd_show_version{}); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case r := <-a.Responses():
+			if r == (Resp_Prompt{}) {
+				return
+			}
+		case <-timeout:
+			t.Fatal("the session blocked on the closed client")
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package agda
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+// Version of agda, e.g. 2.6.1.3
+type Version [4]int
+
+var (
+	// Oldest version whose responses acme-agda decodes, see
+	// testdata/responses
+	MinVersion = Version{2, 6, 1}
+	// Newest version acme-agda was tested with; newer versions are
+	// spoken to like this one.
+	MaxVersion = Version{2, 6, 4}
+)
+
+var versionRegexp = regexp.MustCompile(`([0-9]+)\.([0-9]+)(?:\.([0-9]+))?(?:\.([0-9]+))?`)
+
+// Parses the version in s, e.g. the output of agda --version
+// ("Agda version 2.6.1.3") or Info_Version.
+func ParseVersion(s string) (Version, error) {
+	var version Version
+	match := versionRegexp.FindStringSubmatch(s)
+	if match == nil {
+		return version, fmt.Errorf("no agda version in %q", s)
+	}
+	for i, part := range match[1:] {
+		if part != "" {
+			version[i], _ = strconv.Atoi(part)
+		}
+	}
+	return version, nil
+}
+
+// Runs agda --version.
+func QueryVersion(agdaCmdPath string) (Version, error) {
+	output, err := exec.Command(agdaCmdPath, "--version").Output()
+	if err != nil {
+		return Version{}, fmt.Errorf("cannot determine agda version: %w", err)
+	}
+	return ParseVersion(string(output))
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+	if v[3] != 0 {
+		s += fmt.Sprintf(".%d", v[3])
+	}
+	return s
+}
+
+// Reports whether v is older than w.
+func (v Version) Before(w Version) bool {
+	for i := range v {
+		if v[i] != w[i] {
+			return v[i] < w[i]
+		}
+	}
+	return false
+}
+
+// Returns an error if acme-agda cannot talk to agda v.
+func (v Version) Supported() error {
+	if v.Before(MinVersion) {
+		return fmt.Errorf("agda %s is not supported, at least agda %s is needed", v, MinVersion)
+	}
+	return nil
+}
+
+// Returns an error if cmd does not exist in agda v.
+func (v Version) supports(cmd Command) error {
+	switch cmd.(type) {
+	case ShowIrrelevantArgs, ToggleIrrelevantArgs:
+		if v.Before(Version{2, 6, 2}) {
+			return fmt.Errorf("%T needs agda 2.6.2, running %s", cmd, v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+// Implements -check, which loads Agda files without Acme and reports
+// their errors, warnings and open goals, e.g. for continuous integration.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gitlab.com/neosimsim/acme-agda/agda"
+)
+
+// An error, warning or open goal.
+type diagnostic struct {
+	File     string
+	Line     int
+	Col      int
+	Severity string
+	Message  string
+}
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+	severityGoal    = "goal"
+)
+
+func (d diagnostic) String() string {
+	message := strings.Join(strings.Fields(d.Message), " ")
+	if d.Severity != severityGoal {
+		message = d.Severity + ": " + message
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Col, message)
+}
+
+// Matches the location agda puts in front of a message, like
+// /path/Foo.agda:5,7-10 or, since agda 2.6.4, /path/Foo.agda:5.7-10.
+// Locations mentioned in a message are indented.
+var locationRegexp = regexp.MustCompile(`^(\S.*):([0-9]+)[,.]([0-9]+)(-[0-9]+([,.][0-9]+)?)?$`)
+
+// Splits the messages in text, each starting with its location, into
+// diagnostics. Messages without location are reported for file.
+func parseMessages(text, file, severity string) []diagnostic {
+	var diagnostics []diagnostic
+	for _, line := range strings.Split(text, "\n") {
+		if m := locationRegexp.FindStringSubmatch(line); m != nil {
+			lineNo, _ := strconv.Atoi(m[2])
+			col, _ := strconv.Atoi(m[3])
+			diagnostics = append(diagnostics, diagnostic{File: m[1], Line: lineNo, Col: col, Severity: severity})
+			continue
+		}
+		if strings.TrimSpace(line) == "" && len(diagnostics) == 0 {
+			continue
+		}
+		if len(diagnostics) == 0 {
+			diagnostics = append(diagnostics, diagnostic{File: file, Line: 1, Col: 1, Severity: severity})
+		}
+		d := &diagnostics[len(diagnostics)-1]
+		d.Message = strings.TrimSpace(d.Message + "\n" + line)
+	}
+	return diagnostics
+}
+
+// Returns the diagnostics displayed by info, reporting messages without
+// location for file.
+func infoDiagnostics(info agda.DisplayInfo, file string) []diagnostic {
+	var diagnostics []diagnostic
+	switch info := info.(type) {
+	case agda.Info_Error:
+		diagnostics = append(diagnostics, parseMessages(info.Message, file, severityError)...)
+	case agda.Info_AllGoalsWarnings:
+		for _, goal := range append(info.VisibleGoals, info.InvisibleGoals...) {
+			d := diagnostic{File: file, Line: 1, Col: 1, Severity: severityGoal}
+			if len(goal.ConstraintObj.Range) > 0 {
+				d.Line = goal.ConstraintObj.Range[0].Start.Line
+				d.Col = goal.ConstraintObj.Range[0].Start.Col
+			}
+			d.Message = fmt.Sprintf("%s : %s", goal.ConstraintObj, goal.Type)
+			diagnostics = append(diagnostics, d)
+		}
+		diagnostics = append(diagnostics, parseMessages(info.Errors, file, severityError)...)
+		diagnostics = append(diagnostics, parseMessages(info.Warnings, file, severityWarning)...)
+	}
+	return diagnostics
+}
+
+// Loads the file of a and returns its diagnostics.
+func checkFile(a *agda.Client) ([]diagnostic, error) {
+	if err := a.LoadFile(); err != nil {
+		return nil, err
+	}
+	var diagnostics []diagnostic
+	err := awaitPrompt(a, func(r agda.Response) {
+		if displayInfo, ok := r.(agda.Resp_DisplayInfo); ok {
+			diagnostics = append(diagnostics, infoDiagnostics(displayInfo.Info, a.Filename())...)
+		}
+	})
+	return diagnostics, err
+}
+
+// Checks files and prints the diagnostics to out. Files sharing the
+// agda settings of their configuration are checked with one agda.
+// Returns errAgdaError if any file has errors.
+func check(files []string, out io.Writer, asJSON bool) error {
+	diagnostics, err := diagnoseFiles(files, startAgda)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		if diagnostics == nil {
+			diagnostics = []diagnostic{}
+		}
+		data, err := json.MarshalIndent(diagnostics, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s\n", data)
+	} else {
+		for _, d := range diagnostics {
+			fmt.Fprintln(out, d)
+		}
+	}
+	for _, d := range diagnostics {
+		if d.Severity == severityError {
+			return errAgdaError
+		}
+	}
+	return nil
+}
+
+// Returns the diagnostics of files, loading the configuration of each
+// file's directory and starting agda with start once per distinct setting.
+func diagnoseFiles(files []string, start func(*Config, string) (*agda.Client, error)) ([]diagnostic, error) {
+	type session struct {
+		config *Config
+		client *agda.Client
+	}
+	var sessions []session
+	defer func() {
+		for _, s := range sessions {
+			s.client.Exit()
+		}
+	}()
+	var diagnostics []diagnostic
+	for _, file := range files {
+		file, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		config, err := loadConfig(filepath.Dir(file))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read configuration: %w", err)
+		}
+		var a *agda.Client
+		for _, s := range sessions {
+			if sameAgdaSettings(s.config, config) {
+				a = s.client.Open(file)
+				defer a.Close()
+				break
+			}
+		}
+		if a == nil {
+			if a, err = start(config, file); err != nil {
+				return nil, fmt.Errorf("unable to start agda: %w", err)
+			}
+			sessions = append(sessions, session{config, a})
+		}
+		fileDiagnostics, err := checkFile(a)
+		if err != nil {
+			return nil, err
+		}
+		diagnostics = append(diagnostics, fileDiagnostics...)
+	}
+	return diagnostics, nil
+}
+
+// Reports whether files configured by a and b can be checked by one agda.
+func sameAgdaSettings(a, b *Config) bool {
+	return a.Agda == b.Agda && reflect.DeepEqual(a.AgdaFlags, b.AgdaFlags) && reflect.DeepEqual(a.LoadFlags, b.LoadFlags)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gitlab.com/neosimsim/acme-agda/agda"
+)
+
+func TestParseMessages(t *testing.T) {
+	text := "/tmp/Nat.agda:3,1-7\nEmpty postulate block.\n\n/tmp/Nat.agda:8.5-9.2\nUnreachable clause\n"
+	want := []diagnostic{
+		{File: "/tmp/Nat.agda", Line: 3, Col: 1, Severity: severityWarning, Message: "Empty postulate block."},
+		{File: "/tmp/Nat.agda", Line: 8, Col: 5, Severity: severityWarning, Message: "Unreachable clause"},
+	}
+	if got := parseMessages(text, "/tmp/Other.agda", severityWarning); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	want = []diagnostic{{File: "/tmp/Nat.agda", Line: 1, Col: 1, Severity: severityError, Message: "Failed to read\nthe file"}}
+	if got := parseMessages("Failed to read\nthe file", "/tmp/Nat.agda", severityError); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	text = "/tmp/Nat.agda:5,7-10\nNot in scope:\n  sucx at /tmp/Nat.agda:5,7-10\nwhen scope checking sucx"
+	if got := parseMessages(text, "/tmp/Nat.agda", severityError); len(got) != 1 || got[0].String() != "/tmp/Nat.agda:5:7: error: Not in scope: sucx at /tmp/Nat.agda:5,7-10 when scope checking sucx" {
+		t.Errorf("got %+v", got)
+	}
+	if got := parseMessages("", "/tmp/Nat.agda", severityError); got != nil {
+		t.Errorf("got %+v for no messages", got)
+	}
+}
+
+func TestCheckFile(t *testing.T) {
+	const transcript = `# agda 2.6.1
+< JSON> 
+> load
+< {"kind":"DisplayInfo","info":{"kind":"AllGoalsWarnings","visibleGoals":[{"kind":"OfType","constraintObj":{"id":0,"range":[{"start":{"pos":54,"line":5,"col":7},"end":{"pos":61,"line":5,"col":14}}]},"type":"Nat"}],"invisibleGoals":[],"warnings":"/tmp/Nat.agda:3,1-7\nEmpty postulate block.","errors":""}}
+< JSON> 
+`
+	a, err := agda.Replay(strings.NewReader(transcript), "/tmp/Nat.agda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diagnostics, err := checkFile(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, d := range diagnostics {
+		lines = append(lines, d.String())
+	}
+	want := []string{
+		"/tmp/Nat.agda:5:7: ?0 : Nat",
+		"/tmp/Nat.agda:3:1: warning: Empty postulate block.",
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("got %q\nwant %q", lines, want)
+	}
+}
+
+func TestCheckFilesPerProject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-agda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, project := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(dir, project), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "b", projectConfigName), []byte("include src\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const load = "> load\n" +
+		`< {"kind":"DisplayInfo","info":{"kind":"AllGoalsWarnings","visibleGoals":[],"invisibleGoals":[],"warnings":[],"errors":[]}}` + "\n" +
+		"< JSON> \n"
+	transcripts := map[string]string{
+		"a": "# agda 2.6.2\n< JSON> \n" + load + load,
+		"b": "# agda 2.6.2\n< JSON> \n" + load,
+	}
+	var started []string
+	start := func(config *Config, file string) (*agda.Client, error) {
+		project := filepath.Base(filepath.Dir(file))
+		started = append(started, fmt.Sprintf("%s %q", project, config.LoadFlags))
+		return agda.Replay(strings.NewReader(transcripts[project]), file)
+	}
+	files := []string{
+		filepath.Join(dir, "a", "A.agda"),
+		filepath.Join(dir, "a", "B.agda"),
+		filepath.Join(dir, "b", "C.agda"),
+	}
+	if _, err := diagnoseFiles(files, start); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{`a []`, `b ["-i" "src"]`}; !reflect.DeepEqual(started, want) {
+		t.Errorf("started agda for %q, want %q", started, want)
+	}
+}
@@ -0,0 +1,267 @@
+// Implements the subcommands, which drive agda from the shell:
+//
+//	acme-agda load Foo.agda
+//	acme-agda goals
+//	acme-agda give 3 'suc n'
+//	acme-agda refine 3 suc
+//	acme-agda case 2 n
+//
+// The subcommands only report agda's results: give, refine and case print
+// the text replacing the goal, the Agda file and its window are left alone.
+// The Agda file may be given after the arguments of every subcommand.
+// The subcommands are run by a running acme-agda session, see session.go,
+// which defaults to the file it was started for. Without a session, agda
+// is started for the file given.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gitlab.com/neosimsim/acme-agda/agda"
+)
+
+// A subcommand, as sent to a session.
+type request struct {
+	Command string
+	// Absolute path of the Agda file, empty for the file of the session
+	File string
+	Args []string
+	// Print the responses as JSON instead of text
+	JSON bool
+}
+
+// Number of arguments of the subcommands, not counting the file.
+var subcommandArgs = map[string]int{
+	"load":   0,
+	"goals":  0,
+	"give":   2,
+	"refine": 2,
+	"case":   2,
+}
+
+// Returns the request for the command line args.
+func parseRequest(args []string, asJSON bool) (request, error) {
+	n, ok := subcommandArgs[args[0]]
+	if !ok {
+		return request{}, fmt.Errorf("unknown subcommand %s", args[0])
+	}
+	req := request{Command: args[0], JSON: asJSON}
+	switch len(args) - 1 {
+	case n:
+		req.Args = args[1:]
+	case n + 1:
+		req.Args = args[1 : n+1]
+		file, err := filepath.Abs(args[n+1])
+		if err != nil {
+			return request{}, err
+		}
+		req.File = file
+	default:
+		return request{}, fmt.Errorf("%s expects %d arguments and optionally the Agda file", req.Command, n)
+	}
+	return req, nil
+}
+
+// Sends the agda command for req.
+func (req request) send(a *agda.Client) error {
+	var goal int
+	if len(req.Args) > 0 && req.Command != "infer-toplevel" {
+		var err error
+		if goal, err = strconv.Atoi(req.Args[0]); err != nil {
+			return fmt.Errorf("goal index %s is no number", req.Args[0])
+		}
+	}
+	switch req.Command {
+	case "load":
+		return a.LoadFile()
+	case "goals":
+		return a.Send(agda.Cmd_metas{})
+	case "give":
+		return a.Send(agda.Cmd_give{Force: agda.WithoutForce, Goal: goal, Expr: req.Args[1]})
+	case "refine":
+		return a.RefineHole(goal, req.Args[1])
+	case "case":
+		return a.CaseSplit(goal, req.Args[1])
+	case "type": // the type and context of the goal
+		return a.GoalType(goal, "Simplified")
+	case "infer": // the type of an expression in the goal
+		return a.Send(agda.Cmd_infer{Rewrite: "Simplified", Goal: goal, Expr: req.Args[1]})
+	case "infer-toplevel": // the type of an expression in the file
+		return a.Send(agda.Cmd_infer_toplevel{Rewrite: "Simplified", Expr: req.Args[0]})
+	default:
+		return fmt.Errorf("unknown subcommand %s", req.Command)
+	}
+}
+
+var errAgdaError = errors.New("agda reported an error")
+
+// Runs req with a and passes the responses to handle. The file is loaded
+// first unless it is loaded already. Returns errAgdaError if agda
+// reported an error.
+func runRequest(a *agda.Client, req request, handle func(agda.Response)) error {
+	failed := false
+	if req.Command != "load" && !a.Loaded() {
+		if err := a.LoadFile(); err != nil {
+			return err
+		}
+		err := awaitPrompt(a, func(r agda.Response) {
+			if isError(r) { // the goals are only of interest after the command
+				failed = true
+				handle(r)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := req.send(a); err != nil {
+		return err
+	}
+	err := awaitPrompt(a, func(r agda.Response) {
+		failed = failed || isError(r)
+		handle(r)
+	})
+	if err != nil {
+		return err
+	}
+	if failed {
+		return errAgdaError
+	}
+	return nil
+}
+
+// Passes the responses of a to handle until agda completed the command.
+func awaitPrompt(a *agda.Client, handle func(agda.Response)) error {
+	for r := range a.Responses() {
+		switch r := r.(type) {
+		case agda.Resp_Prompt:
+			return nil
+		case agda.Resp_Exited:
+			return fmt.Errorf("agda exited:\n%s", r.Stderr)
+		case agda.Resp_InternalError:
+			return fmt.Errorf("agda reported an internal error:\n%s", r.Stderr)
+		default:
+			handle(r)
+		}
+	}
+	return errors.New("agda exited")
+}
+
+func isError(r agda.Response) bool {
+	if info, ok := r.(agda.Resp_DisplayInfo); ok {
+		_, isError := info.Info.(agda.Info_Error)
+		return isError
+	}
+	return false
+}
+
+// Writes r to out as text or JSON. Responses only of interest to editors,
+// like highlighting, are left out.
+func (req request) write(out io.Writer, r agda.Response) {
+	switch r.(type) {
+	case agda.Resp_HighlightingInfo, agda.Resp_ClearHighlighting, agda.Resp_ClearRunningInfo:
+		return
+	}
+	if req.JSON {
+		kind := strings.TrimPrefix(fmt.Sprintf("%T", r), "agda.Resp_")
+		var info string
+		if displayInfo, ok := r.(agda.Resp_DisplayInfo); ok {
+			info = strings.TrimPrefix(fmt.Sprintf("%T", displayInfo.Info), "agda.Info_")
+		}
+		data, err := json.Marshal(struct {
+			Kind     string
+			Info     string `json:",omitempty"`
+			Response agda.Response
+		}{kind, info, r})
+		if err != nil {
+			fmt.Fprintf(out, "{\"Error\": %q}\n", err)
+		} else {
+			fmt.Fprintf(out, "%s\n", data)
+		}
+		return
+	}
+	switch r := r.(type) {
+	case agda.Resp_DisplayInfo:
+		writeInfo(out, r.Info)
+	case agda.Resp_GiveAction:
+		switch {
+		case r.GiveResult.Str != "":
+			fmt.Fprintln(out, r.GiveResult.Str)
+		case r.GiveResult.Paren:
+			fmt.Fprintf(out, "(%s)\n", req.Args[1])
+		default:
+			fmt.Fprintln(out, req.Args[1])
+		}
+	case agda.Resp_MakeCase:
+		for _, clause := range r.Clauses {
+			fmt.Fprintln(out, clause)
+		}
+	case agda.Resp_JumpToError:
+		fmt.Fprintf(out, "%s:#%d\n", r.Filepath, r.Position-1)
+	case agda.Resp_SolveAll:
+		for _, solution := range r.Solutions {
+			fmt.Fprintf(out, "?%d := %s\n", solution.InteractionPoint.Id, solution.Expression)
+		}
+	}
+}
+
+func writeInfo(out io.Writer, info agda.DisplayInfo) {
+	switch info := info.(type) {
+	case agda.Info_AllGoalsWarnings:
+		for _, goal := range append(info.VisibleGoals, info.InvisibleGoals...) {
+			fmt.Fprintf(out, "%s : %s\n", goal.ConstraintObj, goal.Type)
+		}
+		writeText(out, info.Errors)
+		writeText(out, info.Warnings)
+	case agda.Info_CompilationOk:
+		writeText(out, info.Errors)
+		writeText(out, info.Warnings)
+	case agda.Info_Error:
+		writeText(out, info.Message)
+	case agda.Info_GoalSpecific:
+		if goalType, ok := info.GoalInfo.(agda.Goal_GoalType); ok {
+			fmt.Fprintf(out, "?%d : %s\n", info.InteractionPoint.Id, goalType.Type)
+		}
+	case agda.Info_Version:
+		writeText(out, info.Version)
+	default:
+		fmt.Fprintf(out, "%v\n", info)
+	}
+}
+
+func writeText(out io.Writer, text string) {
+	if text = strings.TrimRight(text, "\n"); text != "" {
+		fmt.Fprintln(out, text)
+	}
+}
+
+// Runs the subcommand given by args with the running session, or with
+// a new agda process if there is none.
+func runSubcommand(args []string, out io.Writer) error {
+	req, err := parseRequest(args, *jsonOutput)
+	if err != nil {
+		return err
+	}
+	if err := sendRequest(req, out); err != errNoSession {
+		return err
+	}
+	if req.File == "" {
+		return errors.New("no acme-agda session running, name the Agda file")
+	}
+	config, err := loadConfig(filepath.Dir(req.File))
+	if err != nil {
+		return fmt.Errorf("cannot read configuration: %w", err)
+	}
+	a, err := startAgda(config, req.File)
+	if err != nil {
+		return fmt.Errorf("unable to start agda: %w", err)
+	}
+	defer a.Exit()
+	return runRequest(a, req, func(r agda.Response) { req.write(out, r) })
+}
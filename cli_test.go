@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"gitlab.com/neosimsim/acme-agda/agda"
+)
+
+func TestParseRequest(t *testing.T) {
+	req, err := parseRequest([]string{"give", "3", "suc n", "/tmp/Nat.agda"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Command != "give" || req.File != "/tmp/Nat.agda" || len(req.Args) != 2 || req.Args[1] != "suc n" {
+		t.Errorf("got %+v", req)
+	}
+	if req, err := parseRequest([]string{"goals"}, true); err != nil || req.File != "" || !req.JSON {
+		t.Errorf("got %+v, %v", req, err)
+	}
+	for _, args := range [][]string{{"give", "3"}, {"case", "1", "n", "Nat.agda", "extra"}, {"solve"}} {
+		if _, err := parseRequest(args, false); err == nil {
+			t.Errorf("%q parsed", args)
+		}
+	}
+}
+
+const goalsTranscript = `# agda 2.6.2
+< JSON> 
+> load
+< {"kind":"ClearHighlighting","tokenBased":"NotOnlyTokenBased"}
+< {"kind":"DisplayInfo","info":{"kind":"AllGoalsWarnings","visibleGoals":[{"kind":"OfType","constraintObj":{"id":0,"range":[]},"type":"Nat"}],"invisibleGoals":[],"warnings":[],"errors":[]}}
+< JSON> 
+> case
+< {"kind":"MakeCase","variant":"Function","interactionPoint":{"id":0,"range":[]},"clauses":["f zero = ?","f (suc n) = ?"]}
+< JSON> 
+`
+
+func writeTo(out io.Writer, req request) func(agda.Response) {
+	return func(r agda.Response) { req.write(out, r) }
+}
+
+func TestRunRequest(t *testing.T) {
+	a, err := agda.Replay(strings.NewReader(goalsTranscript), "/tmp/Nat.agda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := runRequest(a, request{Command: "load"}, writeTo(&out, request{})); err != nil {
+		t.Fatal(err)
+	}
+	if want := "?0 : Nat\n"; out.String() != want {
+		t.Errorf("load printed %q, want %q", out.String(), want)
+	}
+	out.Reset()
+	req := request{Command: "case", Args: []string{"0", "n"}, JSON: true}
+	if err := runRequest(a, req, writeTo(&out, req)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), `{"Kind":"MakeCase","Response":{`) {
+		t.Errorf("case printed %s", out.String())
+	}
+}
+
+func TestRunRequestLoadsFirst(t *testing.T) {
+	a, err := agda.Replay(strings.NewReader(goalsTranscript), "/tmp/Nat.agda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	req := request{Command: "case", Args: []string{"0", "n"}}
+	if err := runRequest(a, req, writeTo(&out, req)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "f zero = ?\nf (suc n) = ?\n"; out.String() != want {
+		t.Errorf("case printed %q, want %q", out.String(), want)
+	}
+}
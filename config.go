@@ -0,0 +1,293 @@
+// Reads the acme-agda configuration file.
+//
+// The file consists of lines of the form
+//
+//	key value...
+//
+// Empty lines and lines starting with # are ignored.
+// Values are split at white space, e.g.
+//
+//	agda /usr/local/bin/agda
+//	agdaflag --no-libraries
+//	loadflag --safe --without-K
+//	include src
+//	library standard-library
+//	rewrite Normalised
+//	menu Get Case Refine Type Next Goal Input Lookup
+//	tag Give Case Refine Next Goal
+//	template /home/glenda/lib/acme-agda.menu
+//	command Normalise IOTCM {{quote .File}} NonInteractive Direct (Cmd_compute DefaultCompute {{.Goal}} noRange {{quote .Content}})
+//	autoreload start put
+//	reloaddelay 500ms
+//	abortreload on
+//	highlighting indirect
+//	log /tmp/acme-agda.log
+//
+// Autoreload loads the file when the Agda window is opened (start) or
+// put (put), after reloaddelay. An autoreload line replaces the occasions
+// of earlier ones, so a project's "autoreload off" leaves loading to Get.
+//
+// The template replaces the default text of the menu window, see menuText.
+// A command adds a menu command sending an IOTCM line, given as template
+// with the placeholders {{.Goal}}, {{.Content}} and {{.File}} for the
+// interaction point and content of the goal at dot and the Agda file. The
+// function quote writes a Haskell string. The IOTCM is taken verbatim from
+// the rest of the line. A command cannot be named like a command of the
+// menu or of Acme. Menu commands have to be listed by menu to show up.
+// The menu command Config rereads the configuration, except tag, which
+// only takes effect when the Agda window is opened again.
+//
+// Besides the global configuration file, a project may check in a file
+// named .acme-agda. It is looked up in the directory of the Agda file
+// and its parents and its settings override the global ones.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"gitlab.com/neosimsim/acme-agda/agda"
+)
+
+type Config struct {
+	// Name or path of the agda executable
+	Agda string
+	// Extra arguments passed to agda on the command line
+	AgdaFlags []string
+	// Options passed to agda with every Cmd_load
+	LoadFlags []string
+	// Normalisation used when displaying goals and types
+	Rewrite agda.Rewrite
+	// Commands shown in the first line of the menu
+	MenuCommands []string
+	// Commands added to the tag of the Agda window, none by default
+	TagCommands []string
+	// File containing the template of the menu, empty for menuText
+	Template string
+	// IOTCM templates of the user's menu commands by name
+	Commands map[string]string
+	// Occasions on which the file is (re)loaded automatically
+	AutoReload []string
+	// Time to wait for further Puts before reloading
+	ReloadDelay time.Duration
+	// Abort a running check before reloading
+	AbortReload bool
+	// Receive highlighting information in temporary files
+	IndirectHighlighting bool
+	// File to write the log to, empty for standard error
+	Log string
+}
+
+const projectConfigName = ".acme-agda"
+
+var defaultMenuCommands = []string{"Get", "Case", "Refine", "Next", "Goal"}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Agda:         "agda",
+		Rewrite:      "Simplified",
+		MenuCommands: defaultMenuCommands,
+		ReloadDelay:  300 * time.Millisecond,
+	}
+}
+
+// Returns the default location of the configuration file, $HOME/lib/acme-agda.
+func DefaultConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, "lib", "acme-agda")
+}
+
+// Returns the path of the project configuration file in dir or the closest
+// parent directory, or the empty string if there is none.
+func FindProjectConfig(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		path := filepath.Join(dir, projectConfigName)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// Reads the configuration file at path into config. A missing file is not an error.
+func (config *Config) ReadFile(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var err error
+		if fields[0] == "command" { // white space within the IOTCM matters
+			err = config.setCommand(strings.TrimSpace(strings.TrimPrefix(line, fields[0])))
+		} else {
+			err = config.set(fields[0], fields[1:])
+		}
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (config *Config) set(key string, values []string) error {
+	switch key {
+	case "agda":
+		if len(values) != 1 {
+			return fmt.Errorf("%s expects exactly one value", key)
+		}
+		config.Agda = values[0]
+	case "agdaflag":
+		config.AgdaFlags = append(config.AgdaFlags, values...)
+	case "loadflag":
+		config.LoadFlags = append(config.LoadFlags, values...)
+	case "include":
+		for _, dir := range values {
+			config.LoadFlags = append(config.LoadFlags, "-i", dir)
+		}
+	case "library":
+		for _, lib := range values {
+			config.LoadFlags = append(config.LoadFlags, "--library="+lib)
+		}
+	case "rewrite":
+		if len(values) != 1 {
+			return fmt.Errorf("%s expects exactly one value", key)
+		}
+		switch rewrite := agda.Rewrite(values[0]); rewrite {
+		case "AsIs", "Instantiated", "HeadNormal", "Simplified", "Normalised":
+			config.Rewrite = rewrite
+		default:
+			return fmt.Errorf("unknown rewrite mode %q", values[0])
+		}
+	case "menu":
+		config.MenuCommands = values
+	case "tag":
+		config.TagCommands = values
+	case "template":
+		if len(values) != 1 {
+			return fmt.Errorf("%s expects exactly one value", key)
+		}
+		config.Template = values[0]
+	case "autoreload":
+		config.AutoReload = nil // a project replaces the global occasions
+		for _, value := range values {
+			switch value {
+			case "off":
+			case "start", "put":
+				config.AutoReload = append(config.AutoReload, value)
+			default:
+				return fmt.Errorf("unknown autoreload occasion %q", value)
+			}
+		}
+	case "reloaddelay":
+		if len(values) != 1 {
+			return fmt.Errorf("%s expects exactly one value", key)
+		}
+		delay, err := time.ParseDuration(values[0])
+		if err != nil {
+			return err
+		}
+		config.ReloadDelay = delay
+	case "abortreload":
+		if len(values) != 1 {
+			return fmt.Errorf("%s expects exactly one value", key)
+		}
+		switch values[0] {
+		case "on":
+			config.AbortReload = true
+		case "off":
+			config.AbortReload = false
+		default:
+			return fmt.Errorf("%s expects on or off", key)
+		}
+	case "highlighting":
+		if len(values) != 1 {
+			return fmt.Errorf("%s expects exactly one value", key)
+		}
+		switch values[0] {
+		case "direct":
+			config.IndirectHighlighting = false
+		case "indirect":
+			config.IndirectHighlighting = true
+		default:
+			return fmt.Errorf("%s expects direct or indirect", key)
+		}
+	case "log":
+		if len(values) != 1 {
+			return fmt.Errorf("%s expects exactly one value", key)
+		}
+		config.Log = values[0]
+	default:
+		return fmt.Errorf("unknown key %q", key)
+	}
+	return nil
+}
+
+// Adds the user's command given as the name followed by the IOTCM.
+func (config *Config) setCommand(command string) error {
+	i := strings.IndexFunc(command, unicode.IsSpace)
+	if i < 0 {
+		return errors.New("command expects a name and an IOTCM")
+	}
+	name := command[:i]
+	if menuCommands[name] || acmeCommands[name] {
+		return fmt.Errorf("command %s would replace the built-in command", name)
+	}
+	if config.Commands == nil {
+		config.Commands = make(map[string]string)
+	}
+	config.Commands[name] = strings.TrimSpace(command[i:])
+	return nil
+}
+
+// Reports whether the file should be loaded automatically on occasion.
+func (config *Config) AutoReloads(occasion string) bool {
+	for _, o := range config.AutoReload {
+		if o == occasion {
+			return true
+		}
+	}
+	return false
+}
+
+// A flag.Value collecting every occurrence of a repeatable flag.
+type stringList []string
+
+func (list *stringList) String() string {
+	if list == nil {
+		return ""
+	}
+	return strings.Join(*list, " ")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
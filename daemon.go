@@ -0,0 +1,210 @@
+// Serves all Agda windows below a directory with a single agda process,
+// so shared imports are only checked once.
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"9fans.net/go/acme"
+	"9fans.net/go/plumb"
+	"gitlab.com/neosimsim/acme-agda/agda"
+)
+
+type directoryServer struct {
+	dir    string
+	config *Config
+
+	sync.Mutex
+	// Client of the first file served, owns the agda process
+	client *agda.Client
+	// Menus by edit window ID
+	menus map[int]*Menu
+}
+
+// Opens a menu for every Agda window below dir, including windows opened
+// later on, until the acme log cannot be read anymore.
+func serveDirectory(dir string, config *Config) error {
+	d := &directoryServer{dir: dir, config: config, menus: make(map[int]*Menu)}
+	windows, err := acme.Windows()
+	if err != nil {
+		return fmt.Errorf("cannot list acme windows: %w", err)
+	}
+	for _, winInfo := range windows {
+		d.serve(winInfo.ID, winInfo.Name)
+	}
+	defer d.shutdown()
+	if session, err := serveSession(d.session); err != nil {
+		log.Printf("cannot serve subcommands: %s", err)
+	} else {
+		defer session.Close()
+	}
+	if files, err := serveFiles(d.session); err != nil {
+		log.Printf("cannot serve files: %s", err)
+	} else {
+		defer files.Close()
+	}
+	go func() {
+		err := ListenPlumb(definitionPort, d.showDefinition)
+		debugPrint("stopped listening for definitions: %s", err)
+	}()
+	go func() {
+		err := ListenPlumb(namePort, d.explainName)
+		debugPrint("stopped listening for names: %s", err)
+	}()
+	return watchLog(func(event acme.LogEvent) {
+		switch event.Op {
+		case "new", "get":
+			d.serve(event.ID, event.Name)
+		case "put":
+			d.Lock()
+			menu := d.menus[event.ID]
+			d.Unlock()
+			if menu != nil {
+				menu.Put()
+			}
+		case "del":
+			d.Lock()
+			menu := d.menus[event.ID]
+			delete(d.menus, event.ID)
+			d.Unlock()
+			if menu == nil {
+				return
+			}
+			if err := menu.Delete(); err != nil {
+				log.Printf("failed to delete the menu window: %s", err)
+			}
+			menu.agdaInteraction.Close()
+		}
+	})
+}
+
+func isAgdaFile(name string) bool {
+	return strings.HasSuffix(name, ".agda") || strings.Contains(filepath.Base(name), ".lagda")
+}
+
+// Opens a menu for the window, if it shows an Agda file below d.dir
+// and is not served yet. Only called by the goroutine watching the log,
+// d is not locked while agda starts, so the other windows stay served.
+func (d *directoryServer) serve(id int, name string) {
+	if !isAgdaFile(name) || !strings.HasPrefix(name, d.dir+string(filepath.Separator)) {
+		return
+	}
+	d.Lock()
+	_, served := d.menus[id]
+	client := d.client
+	d.Unlock()
+	if served {
+		return
+	}
+	editWin, err := acme.Open(id, nil)
+	if err != nil {
+		log.Printf("cannot open window %d: %s", id, err)
+		return
+	}
+	if err := ResetAddr(editWin); err != nil {
+		log.Printf("cannot reset address of window %d: %s", id, err)
+		editWin.CloseFiles()
+		return
+	}
+	var a *agda.Client
+	if client == nil {
+		if a, err = startAgda(d.config, name); err != nil {
+			log.Printf("unable to start agda: %s", err)
+			editWin.CloseFiles()
+			return
+		}
+		d.Lock()
+		d.client = a
+		d.Unlock()
+	} else {
+		a = client.Open(name)
+	}
+	menu, err := openMenu(a, editWin, d.config)
+	if err != nil {
+		log.Printf("cannot open acme menu for %s: %s", name, err)
+		editWin.CloseFiles()
+		return
+	}
+	if err := menu.Name(name + "+Acme"); err != nil {
+		log.Printf("cannot set acme menu name: %s", err)
+	}
+	d.Lock()
+	d.menus[id] = menu
+	d.Unlock()
+	go func() {
+		menu.Loop()
+		menu.Close()
+		a.Close()
+		d.Lock()
+		delete(d.menus, id)
+		d.Unlock()
+	}()
+}
+
+// Returns the client owning the agda process, nil before the first window is served.
+func (d *directoryServer) session() *agda.Client {
+	d.Lock()
+	defer d.Unlock()
+	return d.client
+}
+
+// Shows the definition of the plumbed name, as seen from the Agda window
+// in the plumbed directory.
+func (d *directoryServer) showDefinition(message *plumb.Message) {
+	d.Lock()
+	var menus []*Menu
+	for _, menu := range d.menus {
+		if filepath.Dir(menu.agdaInteraction.Filename()) == message.Dir {
+			menus = append(menus, menu)
+		}
+	}
+	d.Unlock()
+	for _, menu := range menus {
+		if _, err := menu.DefinitionOf(string(message.Data)); err == nil {
+			showDefinition(menu, string(message.Data))
+			return
+		}
+	}
+	log.Printf("no definition of %s known in %s", message.Data, message.Dir)
+}
+
+// Explains the plumbed name with the Agda window in the plumbed
+// directory, preferring the one agda loaded last.
+func (d *directoryServer) explainName(message *plumb.Message) {
+	d.Lock()
+	var menu *Menu
+	for _, m := range d.menus {
+		if filepath.Dir(m.agdaInteraction.Filename()) != message.Dir {
+			continue
+		}
+		if menu == nil || m.agdaInteraction.Loaded() {
+			menu = m
+		}
+	}
+	d.Unlock()
+	if menu == nil {
+		log.Printf("no Agda window in %s to explain %s", message.Dir, message.Data)
+		return
+	}
+	explainName(menu, message)
+}
+
+// Deletes all menus and stops agda.
+func (d *directoryServer) shutdown() {
+	d.Lock()
+	defer d.Unlock()
+	for _, menu := range d.menus {
+		if err := menu.Delete(); err != nil {
+			log.Printf("failed to delete the menu window: %s", err)
+		}
+	}
+	if d.client != nil {
+		if err := d.client.Exit(); err != nil {
+			log.Printf("agda exited: %s", err)
+		}
+	}
+}
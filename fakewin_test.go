@@ -0,0 +1,440 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"9fans.net/go/acme"
+)
+
+// An in-memory Window. It implements the addresses, ctl messages and
+// files of Acme acme-agda uses, so the frontend can be tested without Acme.
+type fakeWindow struct {
+	sync.Mutex
+	id     int
+	name   string
+	body   []rune
+	tag    string
+	addr   Range
+	dot    Range
+	ctls   []string
+	events chan *acme.Event
+	// Events passed back to Acme with WriteEvent
+	written []*acme.Event
+	deleted bool
+}
+
+var fakeWindowIDs struct {
+	sync.Mutex
+	last int
+}
+
+func newFakeWindow(body string) *fakeWindow {
+	fakeWindowIDs.Lock()
+	defer fakeWindowIDs.Unlock()
+	fakeWindowIDs.last++
+	return &fakeWindow{
+		id:     fakeWindowIDs.last,
+		body:   []rune(body),
+		events: make(chan *acme.Event, 16),
+	}
+}
+
+// Returns the body of the window.
+func (win *fakeWindow) Body() string {
+	win.Lock()
+	defer win.Unlock()
+	return string(win.body)
+}
+
+// Returns the text selected by dot.
+func (win *fakeWindow) Dot() string {
+	win.Lock()
+	defer win.Unlock()
+	return string(win.body[win.dot.Start:win.dot.End])
+}
+
+// Sets dot to the runes q0 to q1.
+func (win *fakeWindow) SetDot(q0, q1 int) {
+	win.Lock()
+	defer win.Unlock()
+	win.dot = Range{Start: q0, End: q1}
+}
+
+// Sets dot to the first occurrence of text.
+func (win *fakeWindow) SelectText(text string) {
+	win.Lock()
+	defer win.Unlock()
+	i := strings.Index(string(win.body), text)
+	if i < 0 {
+		panic(fmt.Sprintf("%q not in body", text))
+	}
+	q0 := utf8.RuneCountInString(string(win.body)[:i])
+	win.dot = Range{Start: q0, End: q0 + utf8.RuneCountInString(text)}
+}
+
+// Places an empty dot before the first occurrence of text.
+func (win *fakeWindow) PlaceDot(text string) {
+	win.SelectText(text)
+	win.Lock()
+	defer win.Unlock()
+	win.dot.End = win.dot.Start
+}
+
+// Returns the ctl messages written so far.
+func (win *fakeWindow) Ctls() []string {
+	win.Lock()
+	defer win.Unlock()
+	return append([]string{}, win.ctls...)
+}
+
+func (win *fakeWindow) ID() int {
+	return win.id
+}
+
+func (win *fakeWindow) Name(format string, args ...interface{}) error {
+	win.Lock()
+	defer win.Unlock()
+	win.name = fmt.Sprintf(format, args...)
+	return nil
+}
+
+func (win *fakeWindow) Addr(format string, args ...interface{}) error {
+	win.Lock()
+	defer win.Unlock()
+	addr, err := win.address(fmt.Sprintf(format, args...), win.addr)
+	if err != nil {
+		return err
+	}
+	win.addr = addr
+	return nil
+}
+
+func (win *fakeWindow) ReadAddr() (q0, q1 int, err error) {
+	win.Lock()
+	defer win.Unlock()
+	return win.addr.Start, win.addr.End, nil
+}
+
+func (win *fakeWindow) Ctl(format string, args ...interface{}) error {
+	win.Lock()
+	defer win.Unlock()
+	ctl := fmt.Sprintf(format, args...)
+	win.ctls = append(win.ctls, ctl)
+	switch ctl {
+	case "addr=dot":
+		win.addr = win.dot
+	case "dot=addr":
+		win.dot = win.addr
+	case "delete", "del":
+		if !win.deleted {
+			win.deleted = true
+			close(win.events)
+		}
+	case "show", "put", "clean", "dirty", "mark", "nomark":
+	default:
+		return fmt.Errorf("unsupported ctl message %q", ctl)
+	}
+	return nil
+}
+
+func (win *fakeWindow) Write(file string, b []byte) (n int, err error) {
+	win.Lock()
+	defer win.Unlock()
+	switch file {
+	case "data", "xdata":
+		text := []rune(string(b))
+		win.replace(win.addr, text)
+		win.addr.Start += len(text)
+		win.addr.End = win.addr.Start
+	case "body":
+		win.replace(Range{Start: len(win.body), End: len(win.body)}, []rune(string(b)))
+	case "tag":
+		win.tag += string(b)
+	default:
+		return 0, fmt.Errorf("unsupported file %s", file)
+	}
+	return len(b), nil
+}
+
+func (win *fakeWindow) ReadAll(file string) ([]byte, error) {
+	win.Lock()
+	defer win.Unlock()
+	switch file {
+	case "body":
+		return []byte(string(win.body)), nil
+	case "xdata":
+		return []byte(string(win.body[win.addr.Start:win.addr.End])), nil
+	case "data":
+		return []byte(string(win.body[win.addr.Start:])), nil
+	default:
+		return nil, fmt.Errorf("unsupported file %s", file)
+	}
+}
+
+func (win *fakeWindow) Selection() string {
+	win.Ctl("addr=dot")
+	data, _ := win.ReadAll("xdata")
+	return string(data)
+}
+
+func (win *fakeWindow) EventChan() <-chan *acme.Event {
+	return win.events
+}
+
+func (win *fakeWindow) WriteEvent(e *acme.Event) error {
+	win.Lock()
+	defer win.Unlock()
+	win.written = append(win.written, e)
+	return nil
+}
+
+func (win *fakeWindow) CloseFiles() {}
+
+// Replaces r by text and moves dot like Acme does.
+func (win *fakeWindow) replace(r Range, text []rune) {
+	body := append([]rune{}, win.body[:r.Start]...)
+	body = append(body, text...)
+	win.body = append(body, win.body[r.End:]...)
+	for _, q := range []*int{&win.dot.Start, &win.dot.End} {
+		if r.Start < *q {
+			*q -= min(r.End, *q) - r.Start
+		}
+		if r.Start < *q {
+			*q += len(text)
+		}
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Evaluates the address s relative to r. Supports #n, line numbers, 0, $, .,
+// /regexp/ and -/regexp/ searches, + and - line offsets, and a,b ranges.
+func (win *fakeWindow) address(s string, r Range) (Range, error) {
+	if i := commaIndex(s); i >= 0 {
+		start, end := Range{}, Range{Start: len(win.body), End: len(win.body)}
+		var err error
+		if s[:i] != "" {
+			if start, err = win.simpleAddress(s[:i], r); err != nil {
+				return Range{}, err
+			}
+		}
+		if s[i+1:] != "" {
+			if end, err = win.simpleAddress(s[i+1:], r); err != nil {
+				return Range{}, err
+			}
+		}
+		if start.Start > end.End {
+			return Range{}, errors.New("addresses out of order")
+		}
+		return Range{Start: start.Start, End: end.End}, nil
+	}
+	return win.simpleAddress(s, r)
+}
+
+// Returns the index of the first comma outside of a regular expression.
+func commaIndex(s string) int {
+	inRegexp := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '/':
+			inRegexp = !inRegexp
+		case ',':
+			if !inRegexp {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func (win *fakeWindow) simpleAddress(s string, r Range) (Range, error) {
+	var err error
+	switch {
+	case s == "":
+		return r, nil
+	case s[0] == '#':
+		var n int
+		if n, s = leadingNumber(s[1:]); n > len(win.body) {
+			return Range{}, errors.New("address out of range")
+		}
+		r = Range{Start: n, End: n}
+	case s[0] >= '0' && s[0] <= '9':
+		var n int
+		n, s = leadingNumber(s)
+		if r, err = win.lineAddress(n, Range{}, 0); err != nil {
+			return Range{}, err
+		}
+	case s[0] == '$':
+		r, s = Range{Start: len(win.body), End: len(win.body)}, s[1:]
+	case s[0] == '.':
+		r, s = win.dot, s[1:]
+	}
+	for s != "" {
+		sign := 1
+		switch s[0] {
+		case '+':
+			s = s[1:]
+		case '-':
+			sign, s = -1, s[1:]
+		case '/':
+		default:
+			return Range{}, fmt.Errorf("bad address %q", s)
+		}
+		switch {
+		case s != "" && s[0] == '/':
+			var re string
+			if re, s, err = leadingRegexp(s[1:]); err != nil {
+				return Range{}, err
+			}
+			if r, err = win.search(re, r, sign); err != nil {
+				return Range{}, err
+			}
+		case s != "" && s[0] >= '0' && s[0] <= '9':
+			var n int
+			n, s = leadingNumber(s)
+			if r, err = win.lineAddress(n, r, sign); err != nil {
+				return Range{}, err
+			}
+		default:
+			if r, err = win.lineAddress(1, r, sign); err != nil {
+				return Range{}, err
+			}
+		}
+	}
+	return r, nil
+}
+
+func leadingNumber(s string) (int, string) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	n, _ := strconv.Atoi(s[:i])
+	return n, s[i:]
+}
+
+// Splits s after the regular expression terminated by an unescaped slash.
+func leadingRegexp(s string) (string, string, error) {
+	var re strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '/':
+			return re.String(), s[i+1:], nil
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == '/':
+			re.WriteByte('/')
+			i++
+		case s[i] == '\\' && i+1 < len(s):
+			re.WriteString(s[i : i+2])
+			i++
+		default:
+			re.WriteByte(s[i])
+		}
+	}
+	return re.String(), "", nil
+}
+
+// Searches forward from the end or backward from the start of r,
+// wrapping around like Acme.
+func (win *fakeWindow) search(expr string, r Range, sign int) (Range, error) {
+	re, err := regexp.Compile("(?m)" + expr)
+	if err != nil {
+		return Range{}, err
+	}
+	text := string(win.body)
+	var matches []Range
+	for _, m := range re.FindAllStringIndex(text, -1) {
+		matches = append(matches, Range{
+			Start: utf8.RuneCountInString(text[:m[0]]),
+			End:   utf8.RuneCountInString(text[:m[1]]),
+		})
+	}
+	if len(matches) == 0 {
+		return Range{}, fmt.Errorf("no match for regexp %q", expr)
+	}
+	if sign > 0 {
+		for _, m := range matches {
+			if m.Start >= r.End {
+				return m, nil
+			}
+		}
+		return matches[0], nil
+	}
+	for i := len(matches) - 1; i >= 0; i-- {
+		if matches[i].End <= r.Start {
+			return matches[i], nil
+		}
+	}
+	return matches[len(matches)-1], nil
+}
+
+// Returns the nth line counted from r in direction sign,
+// or absolute if sign is 0, as Acme's lineaddr does.
+func (win *fakeWindow) lineAddress(n int, r Range, sign int) (Range, error) {
+	body := win.body
+	if sign >= 0 {
+		var p int
+		if n == 0 {
+			if sign == 0 || r.End == 0 {
+				return Range{}, nil
+			}
+			r.Start, p = r.End, r.End
+		} else {
+			count := 1
+			if sign != 0 && r.End != 0 {
+				p, count = r.End-1, 0
+				if body[p] == '\n' {
+					count = 1
+				}
+				p++
+			}
+			for count < n {
+				if p >= len(body) {
+					return Range{}, errors.New("address out of range")
+				}
+				if body[p] == '\n' {
+					count++
+				}
+				p++
+			}
+			r.Start = p
+		}
+		for p < len(body) {
+			p++
+			if body[p-1] == '\n' {
+				break
+			}
+		}
+		r.End = p
+		return r, nil
+	}
+	p := r.Start
+	for p > 0 && body[p-1] != '\n' {
+		p--
+	}
+	for i := 0; i < n; i++ {
+		if p == 0 {
+			if i+1 != n {
+				return Range{}, errors.New("address out of range")
+			}
+			return Range{}, nil
+		}
+		p--
+		for p > 0 && body[p-1] != '\n' {
+			p--
+		}
+	}
+	return win.lineAddress(1, Range{Start: p, End: p}, 1)
+}
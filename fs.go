@@ -0,0 +1,615 @@
+// Serves the session as a 9P file tree on the socket agda in the name
+// space directory of plan9port, e.g. for 9p(1) or 9pfuse(4):
+//
+//	ctl        write load, abort, give N expr, refine N expr or case N var
+//	goals      the open goals, read loads the file unless it is loaded
+//	info       the output of the last command written to ctl or give
+//	errors     the errors and warnings of the last command, as file:line:col: message
+//	N/type     the type of goal N
+//	N/context  the context of goal N
+//	N/give     write an expression to give it to goal N
+//
+// A goal directory exists for every visible goal agda reported last.
+// Commands run by a client sharing the agda process of the session,
+// their responses do not reach the Acme windows. The text replacing a
+// given, refined or split goal is written to info, the Agda file is not
+// changed.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+
+	"9fans.net/go/plan9"
+	"gitlab.com/neosimsim/acme-agda/agda"
+)
+
+type fileServer struct {
+	session func() *agda.Client
+	user    string
+
+	// Held while a command runs, agda answers one command at a time
+	agdaMu sync.Mutex
+	sync.Mutex
+	info   string
+	errors []diagnostic
+	goals  []uint
+}
+
+// Files of the tree. The Qid path of a file in a goal directory is the
+// file number plus the goal index times 8.
+const (
+	qidRoot = iota
+	qidCtl
+	qidGoals
+	qidInfo
+	qidErrors
+)
+
+const (
+	qidGoalDir = iota
+	qidGoalType
+	qidGoalContext
+	qidGoalGive
+	goalFileBits = 3
+)
+
+type node struct {
+	// -1 outside the goal directories
+	goal int
+	file int
+}
+
+var rootFiles = map[string]int{"ctl": qidCtl, "goals": qidGoals, "info": qidInfo, "errors": qidErrors}
+var goalFiles = map[string]int{"type": qidGoalType, "context": qidGoalContext, "give": qidGoalGive}
+
+func (n node) isDir() bool {
+	return n.goal < 0 && n.file == qidRoot || n.goal >= 0 && n.file == qidGoalDir
+}
+
+func (n node) qid() plan9.Qid {
+	path := uint64(n.file)
+	if n.goal >= 0 {
+		path = uint64(n.goal+1)<<goalFileBits | uint64(n.file)
+	}
+	qid := plan9.Qid{Path: path, Type: plan9.QTFILE}
+	if n.isDir() {
+		qid.Type = plan9.QTDIR
+	}
+	return qid
+}
+
+func (n node) name() string {
+	if n.goal >= 0 {
+		if n.file == qidGoalDir {
+			return strconv.Itoa(n.goal)
+		}
+		for name, file := range goalFiles {
+			if file == n.file {
+				return name
+			}
+		}
+	}
+	for name, file := range rootFiles {
+		if file == n.file {
+			return name
+		}
+	}
+	return "/"
+}
+
+func (n node) mode() plan9.Perm {
+	switch {
+	case n.isDir():
+		return plan9.DMDIR | 0555
+	case n.goal < 0 && n.file == qidCtl, n.goal >= 0 && n.file == qidGoalGive:
+		return 0200
+	default:
+		return 0444
+	}
+}
+
+func (fs *fileServer) stat(n node) plan9.Dir {
+	return plan9.Dir{Qid: n.qid(), Mode: n.mode(), Name: n.name(), Uid: fs.user, Gid: fs.user, Muid: fs.user}
+}
+
+// Returns the node name in the directory n.
+func (fs *fileServer) walk(n node, name string) (node, bool) {
+	switch {
+	case name == "..":
+		return node{goal: -1, file: qidRoot}, true
+	case n.goal < 0 && n.file == qidRoot:
+		if file, ok := rootFiles[name]; ok {
+			return node{goal: -1, file: file}, true
+		}
+		if goal, err := strconv.Atoi(name); err == nil && fs.hasGoal(goal) {
+			return node{goal: goal, file: qidGoalDir}, true
+		}
+	case n.isDir():
+		if file, ok := goalFiles[name]; ok {
+			return node{goal: n.goal, file: file}, true
+		}
+	}
+	return node{}, false
+}
+
+func (fs *fileServer) hasGoal(goal int) bool {
+	fs.Lock()
+	defer fs.Unlock()
+	for _, g := range fs.goals {
+		if int(g) == goal {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the entries of the directory n.
+func (fs *fileServer) readDir(n node) []node {
+	if n.goal >= 0 {
+		return []node{{n.goal, qidGoalType}, {n.goal, qidGoalContext}, {n.goal, qidGoalGive}}
+	}
+	nodes := []node{{-1, qidCtl}, {-1, qidGoals}, {-1, qidInfo}, {-1, qidErrors}}
+	fs.Lock()
+	defer fs.Unlock()
+	for _, goal := range fs.goals {
+		nodes = append(nodes, node{int(goal), qidGoalDir})
+	}
+	return nodes
+}
+
+// Returns a client of the session, to be closed once the request is answered.
+func (fs *fileServer) agda() (*agda.Client, error) {
+	session := fs.session()
+	if session == nil {
+		return nil, errors.New("agda is not running yet")
+	}
+	return session.Open(session.Filename()), nil
+}
+
+// Runs req and remembers the goals agda reported. Returns the responses
+// as text and the diagnostics.
+func (fs *fileServer) run(req request) (string, []diagnostic, error) {
+	a, err := fs.agda()
+	if err != nil {
+		return "", nil, err
+	}
+	defer a.Close()
+	fs.agdaMu.Lock()
+	defer fs.agdaMu.Unlock()
+	var out bytes.Buffer
+	var diagnostics []diagnostic
+	err = runRequest(a, req, func(r agda.Response) {
+		req.write(&out, r)
+		switch r := r.(type) {
+		case agda.Resp_DisplayInfo:
+			for _, d := range infoDiagnostics(r.Info, a.Filename()) {
+				if d.Severity != severityGoal {
+					diagnostics = append(diagnostics, d)
+				}
+			}
+			if info, ok := r.Info.(agda.Info_AllGoalsWarnings); ok {
+				fs.setGoals(info.VisibleGoals)
+			}
+		case agda.Resp_InteractionPoints:
+			fs.Lock()
+			fs.goals = nil
+			for _, point := range r.InteractionPoints {
+				fs.goals = append(fs.goals, point.Id)
+			}
+			fs.Unlock()
+		}
+	})
+	return out.String(), diagnostics, err
+}
+
+func (fs *fileServer) setGoals(goals []agda.OutputConstraint) {
+	fs.Lock()
+	defer fs.Unlock()
+	fs.goals = nil
+	for _, goal := range goals {
+		fs.goals = append(fs.goals, goal.ConstraintObj.Id)
+	}
+}
+
+// Runs the command req written to ctl or give.
+func (fs *fileServer) command(req request) error {
+	info, diagnostics, err := fs.run(req)
+	fs.Lock()
+	fs.info, fs.errors = info, diagnostics
+	fs.Unlock()
+	return err
+}
+
+// Parses a line written to ctl.
+func parseCtl(line string) (request, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return request{}, errors.New("empty command")
+	}
+	switch fields[0] {
+	case "load":
+		return request{Command: "load"}, nil
+	case "give", "refine", "case":
+		if len(fields) < 3 {
+			return request{}, fmt.Errorf("%s expects a goal index and an expression", fields[0])
+		}
+		expr := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), fields[0]))
+		expr = strings.TrimSpace(strings.TrimPrefix(expr, fields[1]))
+		return request{Command: fields[0], Args: []string{fields[1], expr}}, nil
+	default:
+		return request{}, fmt.Errorf("unknown command %s", fields[0])
+	}
+}
+
+// Returns the content of the file n, computed when it is opened.
+func (fs *fileServer) content(n node) ([]byte, error) {
+	switch {
+	case n.goal < 0 && n.file == qidGoals:
+		goals, _, err := fs.run(request{Command: "goals"})
+		if err != nil && err != errAgdaError {
+			return nil, err
+		}
+		return []byte(goals), nil
+	case n.goal < 0 && n.file == qidInfo:
+		fs.Lock()
+		defer fs.Unlock()
+		return []byte(fs.info), nil
+	case n.goal < 0 && n.file == qidErrors:
+		fs.Lock()
+		defer fs.Unlock()
+		var text strings.Builder
+		for _, d := range fs.errors {
+			fmt.Fprintln(&text, d)
+		}
+		return []byte(text.String()), nil
+	case n.file == qidGoalType, n.file == qidGoalContext:
+		return fs.goalInfo(n)
+	}
+	return nil, nil
+}
+
+// Returns the type or context of the goal of n.
+func (fs *fileServer) goalInfo(n node) ([]byte, error) {
+	a, err := fs.agda()
+	if err != nil {
+		return nil, err
+	}
+	defer a.Close()
+	fs.agdaMu.Lock()
+	defer fs.agdaMu.Unlock()
+	var text strings.Builder
+	req := request{Command: "type", Args: []string{strconv.Itoa(n.goal)}}
+	err = runRequest(a, req, func(r agda.Response) {
+		if info, ok := r.(agda.Resp_DisplayInfo); !ok {
+			return
+		} else if goal, ok := info.Info.(agda.Info_GoalSpecific); !ok {
+			writeInfo(&text, info.Info)
+		} else if goalType, ok := goal.GoalInfo.(agda.Goal_GoalType); !ok {
+			return
+		} else if n.file == qidGoalType {
+			fmt.Fprintln(&text, goalType.Type)
+		} else {
+			for _, entry := range goalType.Entries {
+				fmt.Fprintf(&text, "%s : %v", entry.ReifiedName, entry.Binding)
+				if !entry.InScope {
+					fmt.Fprint(&text, " (not in scope)")
+				}
+				fmt.Fprintln(&text)
+			}
+		}
+	})
+	if err != nil && err != errAgdaError {
+		return nil, err
+	}
+	return []byte(text.String()), nil
+}
+
+// Handles a write to the file n.
+func (fs *fileServer) write(n node, data []byte) error {
+	switch {
+	case n.goal < 0 && n.file == qidCtl:
+		line := strings.TrimSpace(string(data))
+		if line == "abort" {
+			a, err := fs.agda()
+			if err != nil {
+				return err
+			}
+			defer a.Close()
+			return a.Abort()
+		}
+		req, err := parseCtl(line)
+		if err != nil {
+			return err
+		}
+		return fs.command(req)
+	case n.file == qidGoalGive:
+		return fs.command(request{Command: "give", Args: []string{strconv.Itoa(n.goal), strings.TrimSpace(string(data))}})
+	}
+	return errors.New("permission denied")
+}
+
+// The state of a fid of a connection.
+type fid struct {
+	node node
+	open bool
+	mode uint8
+	// Content of an open file or directory
+	data []byte
+}
+
+// A 9P connection.
+type fsConn struct {
+	fs    *fileServer
+	rw    io.ReadWriter
+	msize uint32
+
+	// Held while writing a reply, guards inflight
+	writeMu sync.Mutex
+	// The unanswered and unflushed request by tag
+	inflight map[uint16]*plan9.Fcall
+	sync.Mutex
+	fids map[uint32]*fid
+}
+
+// Serves the session with the agda process session returns, which
+// returns nil while there is none.
+func serveFiles(session func() *agda.Client) (io.Closer, error) {
+	listener, err := listenNamespace("agda")
+	if err != nil {
+		return nil, err
+	}
+	fs := &fileServer{session: session, user: "none"}
+	if u, err := user.Current(); err == nil {
+		fs.user = u.Username
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				debugPrint("stopped serving files: %s", err)
+				return
+			}
+			go fs.serve(conn)
+		}
+	}()
+	return listener, nil
+}
+
+func (fs *fileServer) serve(conn net.Conn) {
+	defer conn.Close()
+	c := &fsConn{fs: fs, rw: conn, msize: 8192 + plan9.IOHDRSZ, inflight: make(map[uint16]*plan9.Fcall), fids: make(map[uint32]*fid)}
+	for {
+		request, err := plan9.ReadFcall(conn)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("cannot read 9P message: %s", err)
+			}
+			return
+		}
+		debugPrint("9P <- %v", request)
+		if request.Type == plan9.Tversion {
+			c.reply(request, c.version(request))
+		} else {
+			c.writeMu.Lock()
+			c.inflight[request.Tag] = request
+			c.writeMu.Unlock()
+			go func() {
+				c.reply(request, c.handle(request))
+			}()
+		}
+	}
+}
+
+// Replies to request, unless it was flushed. Replying to Tflush forgets
+// the flushed request, so it cannot be answered after Rflush.
+func (c *fsConn) reply(request, response *plan9.Fcall) {
+	response.Tag = request.Tag
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if request.Type != plan9.Tversion {
+		if c.inflight[request.Tag] != request {
+			debugPrint("9P flushed %v", response)
+			return
+		}
+		delete(c.inflight, request.Tag)
+	}
+	if request.Type == plan9.Tflush {
+		delete(c.inflight, request.Oldtag)
+	}
+	debugPrint("9P -> %v", response)
+	if err := plan9.WriteFcall(c.rw, response); err != nil {
+		log.Printf("cannot write 9P message: %s", err)
+	}
+}
+
+func fsError(err error) *plan9.Fcall {
+	return &plan9.Fcall{Type: plan9.Rerror, Ename: err.Error()}
+}
+
+func (c *fsConn) version(request *plan9.Fcall) *plan9.Fcall {
+	c.Lock()
+	defer c.Unlock()
+	if request.Msize < c.msize {
+		c.msize = request.Msize
+	}
+	c.fids = make(map[uint32]*fid)
+	version := plan9.VERSION9P
+	if !strings.HasPrefix(request.Version, plan9.VERSION9P) {
+		version = "unknown"
+	}
+	return &plan9.Fcall{Type: plan9.Rversion, Msize: c.msize, Version: version}
+}
+
+func (c *fsConn) fid(id uint32) (*fid, error) {
+	c.Lock()
+	defer c.Unlock()
+	if f, ok := c.fids[id]; ok {
+		return f, nil
+	}
+	return nil, errors.New("unknown fid")
+}
+
+func (c *fsConn) handle(request *plan9.Fcall) *plan9.Fcall {
+	switch request.Type {
+	case plan9.Tauth:
+		return fsError(errors.New("authentication not required"))
+	case plan9.Tattach:
+		c.Lock()
+		defer c.Unlock()
+		if _, ok := c.fids[request.Fid]; ok {
+			return fsError(errors.New("fid in use"))
+		}
+		root := node{goal: -1, file: qidRoot}
+		c.fids[request.Fid] = &fid{node: root}
+		return &plan9.Fcall{Type: plan9.Rattach, Qid: root.qid()}
+	case plan9.Tflush:
+		return &plan9.Fcall{Type: plan9.Rflush}
+	case plan9.Twalk:
+		return c.walk(request)
+	case plan9.Topen:
+		return c.open(request)
+	case plan9.Tread:
+		return c.read(request)
+	case plan9.Twrite:
+		return c.write(request)
+	case plan9.Tclunk:
+		c.Lock()
+		defer c.Unlock()
+		delete(c.fids, request.Fid)
+		return &plan9.Fcall{Type: plan9.Rclunk}
+	case plan9.Tstat:
+		f, err := c.fid(request.Fid)
+		if err != nil {
+			return fsError(err)
+		}
+		dir := c.fs.stat(f.node)
+		stat, err := dir.Bytes()
+		if err != nil {
+			return fsError(err)
+		}
+		return &plan9.Fcall{Type: plan9.Rstat, Stat: stat}
+	default:
+		return fsError(errors.New("permission denied"))
+	}
+}
+
+func (c *fsConn) walk(request *plan9.Fcall) *plan9.Fcall {
+	f, err := c.fid(request.Fid)
+	if err != nil {
+		return fsError(err)
+	}
+	if f.open {
+		return fsError(errors.New("walk of open fid"))
+	}
+	n := f.node
+	var qids []plan9.Qid
+	for _, name := range request.Wname {
+		next, ok := c.fs.walk(n, name)
+		if !ok {
+			break
+		}
+		n = next
+		qids = append(qids, n.qid())
+	}
+	if len(request.Wname) > 0 && len(qids) == 0 {
+		return fsError(errors.New("file does not exist"))
+	}
+	if len(qids) == len(request.Wname) {
+		c.Lock()
+		defer c.Unlock()
+		if _, ok := c.fids[request.Newfid]; ok && request.Newfid != request.Fid {
+			return fsError(errors.New("fid in use"))
+		}
+		c.fids[request.Newfid] = &fid{node: n}
+	}
+	return &plan9.Fcall{Type: plan9.Rwalk, Wqid: qids}
+}
+
+func (c *fsConn) open(request *plan9.Fcall) *plan9.Fcall {
+	f, err := c.fid(request.Fid)
+	if err != nil {
+		return fsError(err)
+	}
+	mode := request.Mode &^ (plan9.OTRUNC | plan9.OCEXEC)
+	perm := f.node.mode()
+	if (mode == plan9.OREAD || mode == plan9.ORDWR) && perm&0444 == 0 ||
+		(mode == plan9.OWRITE || mode == plan9.ORDWR) && perm&0222 == 0 ||
+		mode == plan9.OEXEC || request.Mode&plan9.ORCLOSE != 0 {
+		return fsError(errors.New("permission denied"))
+	}
+	var data []byte
+	if f.node.isDir() {
+		for _, n := range c.fs.readDir(f.node) {
+			dir := c.fs.stat(n)
+			stat, err := dir.Bytes()
+			if err != nil {
+				return fsError(err)
+			}
+			data = append(data, stat...)
+		}
+	} else if mode != plan9.OWRITE {
+		if data, err = c.fs.content(f.node); err != nil {
+			return fsError(err)
+		}
+	}
+	c.Lock()
+	f.open, f.mode, f.data = true, mode, data
+	c.Unlock()
+	return &plan9.Fcall{Type: plan9.Ropen, Qid: f.node.qid(), Iounit: c.msize - plan9.IOHDRSZ}
+}
+
+func (c *fsConn) read(request *plan9.Fcall) *plan9.Fcall {
+	f, err := c.fid(request.Fid)
+	if err != nil {
+		return fsError(err)
+	}
+	if !f.open || f.mode == plan9.OWRITE {
+		return fsError(errors.New("fid not open for reading"))
+	}
+	count := request.Count
+	if max := c.msize - plan9.IOHDRSZ; count > max {
+		count = max
+	}
+	data := f.data
+	if request.Offset >= uint64(len(data)) {
+		return &plan9.Fcall{Type: plan9.Rread}
+	}
+	data = data[request.Offset:]
+	if f.node.isDir() { // only whole entries
+		n := 0
+		for n < len(data) {
+			size := 2 + (int(data[n]) | int(data[n+1])<<8)
+			if n+size > int(count) {
+				break
+			}
+			n += size
+		}
+		data = data[:n]
+	} else if uint32(len(data)) > count {
+		data = data[:count]
+	}
+	return &plan9.Fcall{Type: plan9.Rread, Data: data}
+}
+
+func (c *fsConn) write(request *plan9.Fcall) *plan9.Fcall {
+	f, err := c.fid(request.Fid)
+	if err != nil {
+		return fsError(err)
+	}
+	if !f.open || f.mode == plan9.OREAD {
+		return fsError(errors.New("fid not open for writing"))
+	}
+	if err := c.fs.write(f.node, request.Data); err != nil {
+		return fsError(err)
+	}
+	return &plan9.Fcall{Type: plan9.Rwrite, Count: uint32(len(request.Data))}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/client"
+	"gitlab.com/neosimsim/acme-agda/agda"
+)
+
+const filesTranscript = `# agda 2.6.2
+< JSON> 
+> load
+< {"kind":"InteractionPoints","interactionPoints":[{"id":0,"range":[]}]}
+< {"kind":"DisplayInfo","info":{"kind":"AllGoalsWarnings","visibleGoals":[],"invisibleGoals":[],"warnings":[],"errors":[]}}
+< JSON> 
+> metas
+< {"kind":"DisplayInfo","info":{"kind":"AllGoalsWarnings","visibleGoals":[{"kind":"OfType","constraintObj":{"id":0,"range":[]},"type":"Nat"}],"invisibleGoals":[],"warnings":[],"errors":[]}}
+< JSON> 
+> case
+< {"kind":"MakeCase","variant":"Function","interactionPoint":{"id":0,"range":[]},"clauses":["f zero = ?","f (suc n) = ?"]}
+< JSON> 
+> goal_type_context
+< {"kind":"DisplayInfo","info":{"kind":"GoalSpecific","interactionPoint":{"id":0,"range":[]},"goalInfo":{"kind":"GoalType","rewrite":"Simplified","typeAux":{"kind":"GoalOnly"},"type":"Nat","entries":[{"originalName":"n","reifiedName":"n","binding":"Nat","inScope":true}],"boundary":[],"outputForms":[]}}}
+< JSON> 
+> goal_type_context
+< {"kind":"DisplayInfo","info":{"kind":"GoalSpecific","interactionPoint":{"id":0,"range":[]},"goalInfo":{"kind":"GoalType","rewrite":"Simplified","typeAux":{"kind":"GoalOnly"},"type":"Nat","entries":[{"originalName":"n","reifiedName":"n","binding":"Nat","inScope":true}],"boundary":[],"outputForms":[]}}}
+< JSON> 
+> give
+< {"kind":"GiveAction","giveResult":{"str":"suc ?"},"interactionPoint":{"id":0,"range":[]}}
+< JSON> 
+> abort
+`
+
+func readFile(t *testing.T, fsys *client.Fsys, name string) string {
+	t.Helper()
+	fid, err := fsys.Open(name, plan9.OREAD)
+	if err != nil {
+		t.Fatalf("cannot open %s: %s", name, err)
+	}
+	defer fid.Close()
+	data, err := ioutil.ReadAll(fid)
+	if err != nil {
+		t.Fatalf("cannot read %s: %s", name, err)
+	}
+	return string(data)
+}
+
+func TestServeFiles(t *testing.T) {
+	ns, err := ioutil.TempDir("", "acme-agda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(ns)
+	defer os.Setenv("NAMESPACE", os.Getenv("NAMESPACE"))
+	os.Setenv("NAMESPACE", ns)
+
+	a, err := agda.Replay(strings.NewReader(filesTranscript), "/tmp/Nat.agda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := serveFiles(func() *agda.Client { return a })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer files.Close()
+	fsys, err := client.Mount("unix", filepath.Join(ns, "agda"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if goals := readFile(t, fsys, "goals"); goals != "?0 : Nat\n" {
+		t.Errorf("goals is %q, want %q", goals, "?0 : Nat\n")
+	}
+	fid, err := fsys.Open("/", plan9.OREAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs, err := fid.Dirreadall()
+	fid.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, dir := range dirs {
+		names = append(names, dir.Name)
+	}
+	if got, want := strings.Join(names, " "), "ctl goals info errors 0"; got != want {
+		t.Errorf("root lists %s, want %s", got, want)
+	}
+	if _, err := fsys.Open("0/give", plan9.OREAD); err == nil {
+		t.Error("0/give opened for reading")
+	}
+
+	ctl, err := fsys.Open("ctl", plan9.OWRITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctl.Close()
+	if _, err := ctl.Write([]byte("case 0 n\n")); err != nil {
+		t.Fatal(err)
+	}
+	if info, want := readFile(t, fsys, "info"), "f zero = ?\nf (suc n) = ?\n"; info != want {
+		t.Errorf("info is %q, want %q", info, want)
+	}
+	if errors := readFile(t, fsys, "errors"); errors != "" {
+		t.Errorf("errors is %q, want none", errors)
+	}
+	if _, err := ctl.Write([]byte("solve 0\n")); err == nil {
+		t.Error("unknown command written to ctl")
+	}
+
+	if goalType := readFile(t, fsys, "0/type"); goalType != "Nat\n" {
+		t.Errorf("0/type is %q, want %q", goalType, "Nat\n")
+	}
+	if context := readFile(t, fsys, "0/context"); context != "n : Nat\n" {
+		t.Errorf("0/context is %q, want %q", context, "n : Nat\n")
+	}
+	give, err := fsys.Open("0/give", plan9.OWRITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := give.Write([]byte("suc ?\n")); err != nil {
+		t.Fatal(err)
+	}
+	give.Close()
+	if info := readFile(t, fsys, "info"); info != "suc ?\n" {
+		t.Errorf("info after give is %q, want %q", info, "suc ?\n")
+	}
+	for _, name := range []string{"1/type", "0/solve", "goals/0", "nosuch"} {
+		if fid, err := fsys.Open(name, plan9.OREAD); err == nil {
+			fid.Close()
+			t.Errorf("walked to %s", name)
+		}
+	}
+	if _, err := ctl.Write([]byte("abort\n")); err != nil {
+		t.Errorf("cannot abort: %s", err)
+	}
+}
+
+func TestFlushSuppressesReply(t *testing.T) {
+	var out bytes.Buffer
+	c := &fsConn{rw: &out, inflight: make(map[uint16]*plan9.Fcall)}
+	read := &plan9.Fcall{Type: plan9.Tread, Tag: 1}
+	flush := &plan9.Fcall{Type: plan9.Tflush, Tag: 2, Oldtag: 1}
+	c.inflight[read.Tag], c.inflight[flush.Tag] = read, flush
+	c.reply(flush, &plan9.Fcall{Type: plan9.Rflush})
+	c.reply(read, &plan9.Fcall{Type: plan9.Rread, Data: []byte("late")})
+	if reply, err := plan9.ReadFcall(&out); err != nil || reply.Type != plan9.Rflush || reply.Tag != 2 {
+		t.Fatalf("first reply is %v, %v, want Rflush", reply, err)
+	}
+	if out.Len() != 0 {
+		t.Error("the flushed request was answered after Rflush")
+	}
+}
@@ -0,0 +1,75 @@
+// Keeps the highlighting information agda sends, to navigate from a name
+// to its definition.
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"gitlab.com/neosimsim/acme-agda/agda"
+)
+
+type Highlighting struct {
+	sync.Mutex
+	// Sorted by start offset, not overlapping
+	tokens []agda.HighlightingToken
+}
+
+// Adds the tokens of info, replacing the tokens they overlap with.
+// Agda's tokens do not overlap each other, so both lists are merged in order.
+func (h *Highlighting) Add(info agda.HighlightingInfo) {
+	added := append([]agda.HighlightingToken(nil), info.Payload...)
+	sort.Slice(added, func(i, j int) bool {
+		return added[i].Range[0] < added[j].Range[0]
+	})
+	h.Lock()
+	defer h.Unlock()
+	old := h.tokens
+	merged := make([]agda.HighlightingToken, 0, len(old)+len(added))
+	i := 0
+	for _, token := range added {
+		// Old tokens are sorted by their end as well, as they do not overlap.
+		kept := i + sort.Search(len(old)-i, func(j int) bool {
+			return old[i+j].Range[1] > token.Range[0]
+		})
+		merged = append(merged, old[i:kept]...)
+		i = kept + sort.Search(len(old)-kept, func(j int) bool {
+			return old[kept+j].Range[0] >= token.Range[1]
+		})
+		merged = append(merged, token)
+	}
+	h.tokens = append(merged, old[i:]...)
+}
+
+func (h *Highlighting) Clear() {
+	h.Lock()
+	defer h.Unlock()
+	h.tokens = nil
+}
+
+// Returns the token at the 0-based character offset q, as used by acme addresses.
+func (h *Highlighting) At(q int) (agda.HighlightingToken, bool) {
+	h.Lock()
+	defer h.Unlock()
+	pos := q + 1
+	i := sort.Search(len(h.tokens), func(i int) bool {
+		return h.tokens[i].Range[1] > pos
+	})
+	if i < len(h.tokens) && h.tokens[i].Range[0] <= pos {
+		return h.tokens[i], true
+	}
+	return agda.HighlightingToken{}, false
+}
+
+// Returns the tokens with a definition site, in order.
+func (h *Highlighting) Definitions() []agda.HighlightingToken {
+	h.Lock()
+	defer h.Unlock()
+	var tokens []agda.HighlightingToken
+	for _, token := range h.tokens {
+		if token.DefinitionSite != nil {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"gitlab.com/neosimsim/acme-agda/agda"
+)
+
+func tokens(ranges ...[2]int) []agda.HighlightingToken {
+	var tokens []agda.HighlightingToken
+	for _, r := range ranges {
+		tokens = append(tokens, agda.HighlightingToken{Range: r})
+	}
+	return tokens
+}
+
+func TestHighlightingAdd(t *testing.T) {
+	var h Highlighting
+	h.Add(agda.HighlightingInfo{Payload: tokens([2]int{10, 12}, [2]int{1, 3}, [2]int{5, 8}, [2]int{20, 25})})
+	h.Add(agda.HighlightingInfo{Payload: tokens([2]int{6, 11}, [2]int{3, 4}, [2]int{24, 30})})
+	want := tokens([2]int{1, 3}, [2]int{3, 4}, [2]int{6, 11}, [2]int{24, 30})
+	if fmt.Sprint(h.tokens) != fmt.Sprint(want) {
+		t.Errorf("tokens are %v, want %v", h.tokens, want)
+	}
+	if token, ok := h.At(6); !ok || token.Range != [2]int{6, 11} {
+		t.Errorf("token at 6 is %v, %v", token, ok)
+	}
+}
+
+func TestHighlightInOrder(t *testing.T) {
+	file, err := ioutil.TempFile("", "acme-agda-highlighting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(`{"remove":false,"payload":[{"range":[1,3],"atoms":[]}]}`); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	var menu Menu
+	responses := make(chan agda.Response, 3)
+	responses <- agda.Resp_HighlightingInfo{Filepath: file.Name()}
+	responses <- agda.Resp_ClearHighlighting{}
+	responses <- agda.Resp_HighlightingInfo{Direct: true, Info: agda.HighlightingInfo{Payload: tokens([2]int{5, 8})}}
+	close(responses)
+	highlight(&menu, responses)
+	if want := tokens([2]int{5, 8}); fmt.Sprint(menu.Highlighting.tokens) != fmt.Sprint(want) {
+		t.Errorf("tokens are %v, want %v", menu.Highlighting.tokens, want)
+	}
+}
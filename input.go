@@ -0,0 +1,88 @@
+// Implements the Agda input method: backslash sequences like \to or \bN
+// are translated to the Unicode characters they stand for.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Returns the translation of the input sequence seq, given without the leading backslash.
+func TranslateInput(seq string) (string, bool) {
+	translation, ok := inputTable[seq]
+	return translation, ok
+}
+
+// Returns the input sequences starting with prefix together with their
+// translations, e.g. `\to →`, sorted by sequence.
+func LookupInput(prefix string) []string {
+	prefix = strings.TrimPrefix(prefix, `\`)
+	var matches []string
+	for seq, translation := range inputTable {
+		if strings.HasPrefix(seq, prefix) {
+			matches = append(matches, `\`+seq+" "+translation)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// Returns the backslash sequence right before dot and its address.
+func InputBeforeDot(win Window) (string, int, int, error) {
+	err := win.Ctl("addr=dot")
+	if err != nil {
+		return "", 0, 0, err
+	}
+	dot, _, err := win.ReadAddr()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if err = win.Addr(`#%d-/\\/`, dot); err != nil {
+		return "", 0, 0, errors.New("no input sequence before dot")
+	}
+	start, _, err := win.ReadAddr()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if start >= dot { // the search wrapped around
+		return "", 0, 0, errors.New("no input sequence before dot")
+	}
+	if err = win.Addr("#%d,#%d", start, dot); err != nil {
+		return "", 0, 0, err
+	}
+	text, err := win.ReadAll("xdata")
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if strings.IndexFunc(string(text), unicode.IsSpace) >= 0 {
+		return "", 0, 0, errors.New("no input sequence before dot")
+	}
+	return string(text), start, dot, nil
+}
+
+// Replaces the backslash sequence before dot by its translation
+// and places dot after the inserted text.
+func ExpandInput(win Window) error {
+	seq, start, end, err := InputBeforeDot(win)
+	if err != nil {
+		return err
+	}
+	translation, ok := TranslateInput(strings.TrimPrefix(seq, `\`))
+	if !ok {
+		return fmt.Errorf("unknown input sequence %s", seq)
+	}
+	if err = win.Addr("#%d,#%d", start, end); err != nil {
+		return err
+	}
+	if _, err = win.Write("data", []byte(translation)); err != nil {
+		return err
+	}
+	if err = win.Addr("#%d", start+utf8.RuneCountInString(translation)); err != nil {
+		return err
+	}
+	return win.Ctl("dot=addr")
+}
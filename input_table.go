@@ -0,0 +1,464 @@
+package main
+
+// Translations of the Agda input method, a subset of agda-input.el
+// covering the symbols commonly used in Agda code.
+var inputTable = map[string]string{
+	// Arrows
+	"to":             "→",
+	"->":             "→",
+	"r":              "→",
+	"r-":             "→",
+	"l":              "←",
+	"l-":             "←",
+	"<-":             "←",
+	"u":              "↑",
+	"d":              "↓",
+	"lr":             "↔",
+	"<->":            "↔",
+	"ud":             "↕",
+	"=>":             "⇒",
+	"r=":             "⇒",
+	"l=":             "⇐",
+	"<=>":            "⇔",
+	"lr=":            "⇔",
+	"r==":            "⇛",
+	"l==":            "⇚",
+	"r~":             "↝",
+	"l~":             "↜",
+	"r--":            "⟶",
+	"l--":            "⟵",
+	"lr--":           "⟷",
+	"r->":            "↠",
+	"r>":             "↣",
+	"mapsto":         "↦",
+	"r|":             "↦",
+	"dr":             "↘",
+	"dl":             "↙",
+	"ur":             "↗",
+	"ul":             "↖",
+	"leadsto":        "↝",
+	"hookrightarrow": "↪",
+	"hookleftarrow":  "↩",
+
+	// Relations
+	"==":       "≡",
+	"equiv":    "≡",
+	"=n":       "≠",
+	"ne":       "≠",
+	"neq":      "≠",
+	"==n":      "≢",
+	"nequiv":   "≢",
+	"~":        "∼",
+	"sim":      "∼",
+	"~~":       "≈",
+	"approx":   "≈",
+	"~-":       "≃",
+	"simeq":    "≃",
+	"~=":       "≅",
+	"cong":     "≅",
+	"~n":       "≁",
+	"=?":       "≟",
+	":=":       "≔",
+	"=:":       "≕",
+	"<=":       "≤",
+	"le":       "≤",
+	"leq":      "≤",
+	">=":       "≥",
+	"ge":       "≥",
+	"geq":      "≥",
+	"<=n":      "≰",
+	">=n":      "≱",
+	"<n":       "≮",
+	">n":       "≯",
+	"<<":       "≪",
+	">>":       "≫",
+	"<~":       "≲",
+	">~":       "≳",
+	"prec":     "≺",
+	"succ":     "≻",
+	"preceq":   "≼",
+	"succeq":   "≽",
+	"|-":       "⊢",
+	"vdash":    "⊢",
+	"-|":       "⊣",
+	"|=":       "⊨",
+	"models":   "⊨",
+	"||-":      "⊩",
+	"|-n":      "⊬",
+	"qed":      "∎",
+	"top":      "⊤",
+	"bot":      "⊥",
+	"perp":     "⊥",
+	"mid":      "∣",
+	"nmid":     "∤",
+	"parallel": "∥",
+
+	// Logic
+	"all":      "∀",
+	"forall":   "∀",
+	"ex":       "∃",
+	"exists":   "∃",
+	"exn":      "∄",
+	"nexists":  "∄",
+	"and":      "∧",
+	"wedge":    "∧",
+	"or":       "∨",
+	"vee":      "∨",
+	"neg":      "¬",
+	"lnot":     "¬",
+	"not":      "¬",
+	"bigwedge": "⋀",
+	"bigvee":   "⋁",
+
+	// Sets
+	"in":         "∈",
+	"inn":        "∉",
+	"notin":      "∉",
+	"ni":         "∋",
+	"nin":        "∌",
+	"0":          "∅",
+	"emptyset":   "∅",
+	"empty":      "∅",
+	"cap":        "∩",
+	"cup":        "∪",
+	"u+":         "⊎",
+	"uplus":      "⊎",
+	"sqcap":      "⊓",
+	"sqcup":      "⊔",
+	"glb":        "⊓",
+	"lub":        "⊔",
+	"sub":        "⊂",
+	"subset":     "⊂",
+	"sup":        "⊃",
+	"supset":     "⊃",
+	"sub=":       "⊆",
+	"subseteq":   "⊆",
+	"sup=":       "⊇",
+	"supseteq":   "⊇",
+	"subn":       "⊄",
+	"sub=n":      "⊈",
+	"setminus":   "∖",
+	"bigcap":     "⋂",
+	"bigcup":     "⋃",
+	"biguplus":   "⨄",
+	"complement": "∁",
+
+	// Operators
+	"o":         "∘",
+	"circ":      "∘",
+	"comp":      "∘",
+	".":         "∙",
+	"cdot":      "·",
+	"bullet":    "•",
+	"x":         "×",
+	"times":     "×",
+	"div":       "÷",
+	"pm":        "±",
+	"mp":        "∓",
+	"o+":        "⊕",
+	"oplus":     "⊕",
+	"o-":        "⊖",
+	"ominus":    "⊖",
+	"ox":        "⊗",
+	"otimes":    "⊗",
+	"o/":        "⊘",
+	"o.":        "⊙",
+	"odot":      "⊙",
+	"oo":        "⊚",
+	"ast":       "∗",
+	"star":      "⋆",
+	"*":         "⋆",
+	"sum":       "∑",
+	"prod":      "∏",
+	"coprod":    "∐",
+	"Sigma":     "Σ",
+	"Pi":        "Π",
+	"inf":       "∞",
+	"infty":     "∞",
+	"sqrt":      "√",
+	"partial":   "∂",
+	"nabla":     "∇",
+	"Box":       "□",
+	"Diamond":   "◇",
+	"triangle":  "△",
+	"lozenge":   "◊",
+	"dagger":    "†",
+	"ddagger":   "‡",
+	"ell":       "ℓ",
+	"wp":        "℘",
+	"Im":        "ℑ",
+	"Re":        "ℜ",
+	"aleph":     "ℵ",
+	"hbar":      "ℏ",
+	"'":         "′",
+	"''":        "″",
+	"'''":       "‴",
+	"...":       "…",
+	"ldots":     "…",
+	"cdots":     "⋯",
+	"vdots":     "⋮",
+	"ddots":     "⋱",
+	"::":        "∷",
+	"::-":       "∺",
+	"-:":        "∹",
+	"|":         "∣",
+	"||":        "∥",
+	"==>":       "⟹",
+	"--":        "–",
+	"---":       "—",
+	"lambda":    "λ",
+	"Lambda":    "Λ",
+	"cent":      "¢",
+	"pound":     "£",
+	"euro":      "€",
+	"yen":       "¥",
+	"S":         "§",
+	"P":         "¶",
+	"copyright": "©",
+	"degree":    "°",
+	"deg":       "°",
+	"checkmark": "✓",
+
+	// Brackets
+	"{{":     "⦃",
+	"}}":     "⦄",
+	"((":     "⦅",
+	"))":     "⦆",
+	"[[":     "⟦",
+	"]]":     "⟧",
+	"<":      "⟨",
+	">":      "⟩",
+	"<<<":    "⟪",
+	">>>":    "⟫",
+	"langle": "⟨",
+	"rangle": "⟩",
+	"lceil":  "⌈",
+	"rceil":  "⌉",
+	"lfloor": "⌊",
+	"rfloor": "⌋",
+	"cul":    "⌜",
+	"cur":    "⌝",
+	"cll":    "⌞",
+	"clr":    "⌟",
+	"lbag":   "⟅",
+	"rbag":   "⟆",
+	"[|":     "⟦",
+	"|]":     "⟧",
+
+	// Greek
+	"Ga":      "α",
+	"GA":      "Α",
+	"Gb":      "β",
+	"GB":      "Β",
+	"Gg":      "γ",
+	"GG":      "Γ",
+	"Gd":      "δ",
+	"GD":      "Δ",
+	"Ge":      "ε",
+	"GE":      "Ε",
+	"Gz":      "ζ",
+	"GZ":      "Ζ",
+	"Gh":      "η",
+	"GH":      "Η",
+	"Gth":     "θ",
+	"GTH":     "Θ",
+	"Gi":      "ι",
+	"GI":      "Ι",
+	"Gk":      "κ",
+	"GK":      "Κ",
+	"Gl":      "λ",
+	"GL":      "Λ",
+	"Gm":      "μ",
+	"GM":      "Μ",
+	"Gn":      "ν",
+	"GN":      "Ν",
+	"Gx":      "ξ",
+	"GX":      "Ξ",
+	"Gr":      "ρ",
+	"GR":      "Ρ",
+	"Gs":      "σ",
+	"GS":      "Σ",
+	"Gt":      "τ",
+	"GT":      "Τ",
+	"Gu":      "υ",
+	"GU":      "Υ",
+	"Gf":      "φ",
+	"GF":      "Φ",
+	"Gc":      "χ",
+	"GC":      "Χ",
+	"Gp":      "ψ",
+	"GP":      "Ψ",
+	"Go":      "ω",
+	"GO":      "Ω",
+	"alpha":   "α",
+	"beta":    "β",
+	"gamma":   "γ",
+	"Gamma":   "Γ",
+	"delta":   "δ",
+	"Delta":   "Δ",
+	"epsilon": "ε",
+	"zeta":    "ζ",
+	"eta":     "η",
+	"theta":   "θ",
+	"Theta":   "Θ",
+	"iota":    "ι",
+	"kappa":   "κ",
+	"mu":      "μ",
+	"nu":      "ν",
+	"xi":      "ξ",
+	"Xi":      "Ξ",
+	"pi":      "π",
+	"rho":     "ρ",
+	"sigma":   "σ",
+	"tau":     "τ",
+	"upsilon": "υ",
+	"phi":     "φ",
+	"Phi":     "Φ",
+	"chi":     "χ",
+	"psi":     "ψ",
+	"Psi":     "Ψ",
+	"omega":   "ω",
+	"Omega":   "Ω",
+
+	// Blackboard bold
+	"bA": "𝔸",
+	"bB": "𝔹",
+	"bC": "ℂ",
+	"bD": "𝔻",
+	"bE": "𝔼",
+	"bF": "𝔽",
+	"bG": "𝔾",
+	"bH": "ℍ",
+	"bI": "𝕀",
+	"bJ": "𝕁",
+	"bK": "𝕂",
+	"bL": "𝕃",
+	"bM": "𝕄",
+	"bN": "ℕ",
+	"bO": "𝕆",
+	"bP": "ℙ",
+	"bQ": "ℚ",
+	"bR": "ℝ",
+	"bS": "𝕊",
+	"bT": "𝕋",
+	"bU": "𝕌",
+	"bV": "𝕍",
+	"bW": "𝕎",
+	"bX": "𝕏",
+	"bY": "𝕐",
+	"bZ": "ℤ",
+	"b0": "𝟘",
+	"b1": "𝟙",
+	"b2": "𝟚",
+
+	// Calligraphic
+	"MCA": "𝓐",
+	"MCB": "𝓑",
+	"MCC": "𝓒",
+	"MCD": "𝓓",
+	"MCE": "𝓔",
+	"MCF": "𝓕",
+	"MCG": "𝓖",
+	"MCH": "𝓗",
+	"MCI": "𝓘",
+	"MCL": "𝓛",
+	"MCM": "𝓜",
+	"MCP": "𝓟",
+	"MCR": "𝓡",
+	"MCS": "𝓢",
+	"MCU": "𝓤",
+	"MCV": "𝓥",
+
+	// Subscripts and superscripts
+	"_0": "₀",
+	"_1": "₁",
+	"_2": "₂",
+	"_3": "₃",
+	"_4": "₄",
+	"_5": "₅",
+	"_6": "₆",
+	"_7": "₇",
+	"_8": "₈",
+	"_9": "₉",
+	"_+": "₊",
+	"_-": "₋",
+	"_=": "₌",
+	"_(": "₍",
+	"_)": "₎",
+	"_a": "ₐ",
+	"_e": "ₑ",
+	"_h": "ₕ",
+	"_i": "ᵢ",
+	"_j": "ⱼ",
+	"_k": "ₖ",
+	"_l": "ₗ",
+	"_m": "ₘ",
+	"_n": "ₙ",
+	"_o": "ₒ",
+	"_p": "ₚ",
+	"_r": "ᵣ",
+	"_s": "ₛ",
+	"_t": "ₜ",
+	"_u": "ᵤ",
+	"_v": "ᵥ",
+	"_x": "ₓ",
+	"^0": "⁰",
+	"^1": "¹",
+	"^2": "²",
+	"^3": "³",
+	"^4": "⁴",
+	"^5": "⁵",
+	"^6": "⁶",
+	"^7": "⁷",
+	"^8": "⁸",
+	"^9": "⁹",
+	"^+": "⁺",
+	"^-": "⁻",
+	"^=": "⁼",
+	"^(": "⁽",
+	"^)": "⁾",
+	"^a": "ᵃ",
+	"^b": "ᵇ",
+	"^c": "ᶜ",
+	"^d": "ᵈ",
+	"^e": "ᵉ",
+	"^f": "ᶠ",
+	"^g": "ᵍ",
+	"^h": "ʰ",
+	"^i": "ⁱ",
+	"^j": "ʲ",
+	"^k": "ᵏ",
+	"^l": "ˡ",
+	"^m": "ᵐ",
+	"^n": "ⁿ",
+	"^o": "ᵒ",
+	"^p": "ᵖ",
+	"^r": "ʳ",
+	"^s": "ˢ",
+	"^t": "ᵗ",
+	"^u": "ᵘ",
+	"^v": "ᵛ",
+	"^w": "ʷ",
+	"^x": "ˣ",
+	"^y": "ʸ",
+	"^z": "ᶻ",
+	"^A": "ᴬ",
+	"^B": "ᴮ",
+	"^D": "ᴰ",
+	"^E": "ᴱ",
+	"^G": "ᴳ",
+	"^H": "ᴴ",
+	"^I": "ᴵ",
+	"^J": "ᴶ",
+	"^K": "ᴷ",
+	"^L": "ᴸ",
+	"^M": "ᴹ",
+	"^N": "ᴺ",
+	"^O": "ᴼ",
+	"^P": "ᴾ",
+	"^R": "ᴿ",
+	"^T": "ᵀ",
+	"^U": "ᵁ",
+	"^V": "ⱽ",
+	"^W": "ᵂ",
+}
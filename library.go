@@ -0,0 +1,102 @@
+// Detects the Agda library (.agda-lib) a file belongs to.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type AgdaLib struct {
+	// Path of the .agda-lib file
+	Path    string
+	Name    string
+	Include []string
+	Depend  []string
+}
+
+var errNoAgdaLib = errors.New("no .agda-lib found")
+
+// Returns the nearest .agda-lib file found in the directory of filename
+// or any of its parents, the same way agda looks it up.
+func FindAgdaLib(filename string) (*AgdaLib, error) {
+	dir, err := filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		return nil, err
+	}
+	for {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.agda-lib"))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) > 0 {
+			return ReadAgdaLib(matches[0])
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, errNoAgdaLib
+		}
+		dir = parent
+	}
+}
+
+// Parses the fields name, include and depend of an .agda-lib file.
+// Field values may continue on indented lines.
+func ReadAgdaLib(path string) (*AgdaLib, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	lib := AgdaLib{Path: path}
+	var field *[]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "--"); i >= 0 {
+			line = line[:i] // drop comments
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if field != nil {
+				*field = append(*field, splitList(line)...)
+			}
+			continue
+		}
+		field = nil
+		key, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			key, value = strings.TrimSpace(line[:i]), line[i+1:]
+		}
+		switch key {
+		case "name":
+			lib.Name = strings.TrimSpace(value)
+		case "include":
+			field = &lib.Include
+		case "depend":
+			field = &lib.Depend
+		}
+		if field != nil {
+			*field = append(*field, splitList(value)...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if lib.Name == "" {
+		lib.Name = strings.TrimSuffix(filepath.Base(path), ".agda-lib")
+	}
+	return &lib, nil
+}
+
+func splitList(s string) []string {
+	return strings.Fields(strings.ReplaceAll(s, ",", " "))
+}
+
+func (lib *AgdaLib) String() string {
+	return lib.Name + " (" + lib.Path + ")"
+}
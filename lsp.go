@@ -0,0 +1,788 @@
+// Implements -lsp, a language server speaking the Language Server
+// Protocol on stdin and stdout, for editors other than Acme:
+//
+//	opening or saving a file loads it
+//	errors, warnings and goals are published as diagnostics
+//	code actions give, refine or case split the goal at the cursor
+//	hover shows the type of the name or goal at the cursor
+//	definition jumps to the definition site agda highlighted
+//
+// All files share one agda process. Goals are found in the text like in
+// Acme, see goalAddress, and are available until the text has as many
+// goals as agda reported.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"gitlab.com/neosimsim/acme-agda/agda"
+)
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+var lspSeverities = map[string]int{severityError: 1, severityWarning: 2, severityGoal: 3}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspCommand struct {
+	Title     string        `json:"title"`
+	Command   string        `json:"command"`
+	Arguments []interface{} `json:"arguments"`
+}
+
+type lspCodeAction struct {
+	Title   string     `json:"title"`
+	Kind    string     `json:"kind"`
+	Command lspCommand `json:"command"`
+}
+
+// The parameters of the requests and notifications handled.
+type lspParams struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+	Position  lspPosition       `json:"position"`
+	Range     lspRange          `json:"range"`
+	Command   string            `json:"command"`
+	Arguments []json.RawMessage `json:"arguments"`
+}
+
+type lspMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// Error codes of JSON-RPC
+const (
+	lspInvalidParams  = -32602
+	lspMethodNotFound = -32601
+	lspRequestFailed  = -32803
+)
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (err lspError) Error() string {
+	return err.Message
+}
+
+// The commands offered as code actions on goals.
+var lspCommands = map[string]string{
+	"agda.give":   "give",
+	"agda.refine": "refine",
+	"agda.case":   "case",
+}
+
+// An open file.
+type lspDocument struct {
+	uri  string
+	file string
+	text string
+	// Interaction points agda reported, in the order of the goals in the text
+	goals        []uint
+	highlighting Highlighting
+	// URIs of other files diagnostics were published for
+	related []string
+}
+
+type lspServer struct {
+	// Starts the agda process for the first file opened
+	start func(file string) (*agda.Client, error)
+
+	writeMu sync.Mutex
+	out     io.Writer
+	// Held while a command runs, agda answers one command at a time
+	agdaMu sync.Mutex
+	sync.Mutex
+	agda      *agda.Client
+	documents map[string]*lspDocument
+	nextID    int
+}
+
+// Serves the Language Server Protocol on in and out until the client exits.
+func serveLSP(in io.Reader, out io.Writer) error {
+	s := &lspServer{start: startLSPAgda, out: out, documents: make(map[string]*lspDocument)}
+	return s.serve(in)
+}
+
+func startLSPAgda(file string) (*agda.Client, error) {
+	config, err := loadConfig(filepath.Dir(file))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read configuration: %w", err)
+	}
+	a, err := startAgda(config, file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start agda: %w", err)
+	}
+	a.SetIndirectHighlighting(config.IndirectHighlighting)
+	log.Printf("using agda %s", a.Version())
+	return a, nil
+}
+
+func (s *lspServer) serve(in io.Reader) error {
+	defer func() {
+		s.Lock()
+		defer s.Unlock()
+		if s.agda != nil {
+			s.agda.Exit()
+		}
+	}()
+	reader := textproto.NewReader(bufio.NewReader(in))
+	for {
+		message, err := readLSPMessage(reader)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		debugPrint("lsp <- %s %s", message.Method, message.Params)
+		if message.Method == "exit" {
+			return nil
+		}
+		s.handle(message)
+	}
+}
+
+// Size of the largest message read, large enough for any Agda file
+const maxLSPMessage = 64 << 20
+
+// Reads a message framed by a Content-Length header.
+func readLSPMessage(reader *textproto.Reader) (lspMessage, error) {
+	var message lspMessage
+	header, err := reader.ReadMIMEHeader()
+	if err == io.EOF {
+		return message, err
+	} else if err != nil {
+		return message, fmt.Errorf("cannot read message: %w", err)
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return message, fmt.Errorf("malformed message length: %w", err)
+	} else if length < 0 || length > maxLSPMessage {
+		return message, fmt.Errorf("message length %d out of range", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader.R, body); err != nil {
+		return message, fmt.Errorf("cannot read message: %w", err)
+	}
+	if err := json.Unmarshal(body, &message); err != nil {
+		return message, fmt.Errorf("malformed message: %w", err)
+	}
+	return message, nil
+}
+
+// Handles a message. Notifications changing the documents are handled
+// in order, everything else concurrently.
+func (s *lspServer) handle(message lspMessage) {
+	var params lspParams
+	if len(message.Params) > 0 {
+		if err := json.Unmarshal(message.Params, &params); err != nil {
+			s.reply(message.ID, nil, lspError{lspInvalidParams, err.Error()})
+			return
+		}
+	}
+	uri := params.TextDocument.URI
+	switch message.Method {
+	case "initialize":
+		s.reply(message.ID, map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync": map[string]interface{}{
+					"openClose": true,
+					"change":    1, // the full text
+					"save":      true,
+				},
+				"hoverProvider":      true,
+				"definitionProvider": true,
+				"codeActionProvider": true,
+				"executeCommandProvider": map[string]interface{}{
+					"commands": []string{"agda.give", "agda.refine", "agda.case"},
+				},
+			},
+			"serverInfo": map[string]string{"name": "acme-agda"},
+		}, nil)
+	case "shutdown":
+		s.reply(message.ID, nil, nil)
+	case "textDocument/didOpen":
+		file, err := uriFile(uri)
+		if err != nil {
+			log.Printf("cannot open %s: %s", uri, err)
+			return
+		}
+		s.Lock()
+		s.documents[uri] = &lspDocument{uri: uri, file: file, text: params.TextDocument.Text}
+		s.Unlock()
+		go s.load(uri)
+	case "textDocument/didChange":
+		s.Lock()
+		if doc, ok := s.documents[uri]; ok && len(params.ContentChanges) > 0 {
+			doc.text = params.ContentChanges[len(params.ContentChanges)-1].Text
+		}
+		s.Unlock()
+	case "textDocument/didSave":
+		go s.load(uri)
+	case "textDocument/didClose":
+		s.Lock()
+		delete(s.documents, uri)
+		s.Unlock()
+	case "textDocument/hover":
+		go func() {
+			result, err := s.hover(uri, params.Position)
+			s.reply(message.ID, result, err)
+		}()
+	case "textDocument/definition":
+		go func() {
+			result, err := s.definition(uri, params.Position)
+			s.reply(message.ID, result, err)
+		}()
+	case "textDocument/codeAction":
+		result, err := s.codeActions(uri, params.Range)
+		s.reply(message.ID, result, err)
+	case "workspace/executeCommand":
+		go func() {
+			err := s.execute(params.Command, params.Arguments)
+			s.reply(message.ID, nil, err)
+		}()
+	default:
+		if message.ID != nil && message.Method != "" {
+			s.reply(message.ID, nil, lspError{lspMethodNotFound, "method not supported: " + message.Method})
+		}
+	}
+}
+
+func (s *lspServer) write(message map[string]interface{}) {
+	message["jsonrpc"] = "2.0"
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("cannot encode message: %s", err)
+		return
+	}
+	debugPrint("lsp -> %s", data)
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if _, err := fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data); err != nil {
+		log.Printf("cannot write message: %s", err)
+	}
+}
+
+// Answers the request id, unless it is a notification.
+func (s *lspServer) reply(id json.RawMessage, result interface{}, err error) {
+	if id == nil {
+		return
+	}
+	if err == nil {
+		s.write(map[string]interface{}{"id": id, "result": result})
+	} else if e, ok := err.(lspError); ok {
+		s.write(map[string]interface{}{"id": id, "error": e})
+	} else {
+		s.write(map[string]interface{}{"id": id, "error": lspError{lspRequestFailed, err.Error()}})
+	}
+}
+
+func (s *lspServer) notify(method string, params interface{}) {
+	s.write(map[string]interface{}{"method": method, "params": params})
+}
+
+// Sends a request to the client, ignoring the answer.
+func (s *lspServer) request(method string, params interface{}) {
+	s.Lock()
+	s.nextID++
+	id := s.nextID
+	s.Unlock()
+	s.write(map[string]interface{}{"id": id, "method": method, "params": params})
+}
+
+func (s *lspServer) showError(err error) {
+	s.notify("window/showMessage", map[string]interface{}{"type": 1, "message": err.Error()})
+}
+
+func (s *lspServer) document(uri string) (*lspDocument, error) {
+	s.Lock()
+	defer s.Unlock()
+	if doc, ok := s.documents[uri]; ok {
+		return doc, nil
+	}
+	return nil, fmt.Errorf("%s is not open", uri)
+}
+
+// Returns the text of the open file with the path file, or its content.
+func (s *lspServer) text(file string) (string, error) {
+	s.Lock()
+	for _, doc := range s.documents {
+		if doc.file == file {
+			defer s.Unlock()
+			return doc.text, nil
+		}
+	}
+	s.Unlock()
+	data, err := ioutil.ReadFile(file)
+	return string(data), err
+}
+
+// Runs req with the client of doc, starting agda if needed, and passes
+// the responses to handle. Keeps the goals and highlighting of doc.
+func (s *lspServer) run(doc *lspDocument, req request, handle func(agda.Response)) error {
+	s.agdaMu.Lock()
+	defer s.agdaMu.Unlock()
+	if s.agda == nil {
+		a, err := s.start(doc.file)
+		if err != nil {
+			return err
+		}
+		a.Close() // only used to open the clients of the requests
+		s.Lock()
+		s.agda = a
+		s.Unlock()
+	}
+	client := s.agda.Open(doc.file)
+	defer client.Close()
+	return runRequest(client, req, func(r agda.Response) {
+		switch r := r.(type) {
+		case agda.Resp_InteractionPoints:
+			s.Lock()
+			doc.goals = nil
+			for _, point := range r.InteractionPoints {
+				doc.goals = append(doc.goals, point.Id)
+			}
+			s.Unlock()
+		case agda.Resp_HighlightingInfo:
+			if r.Direct {
+				doc.highlighting.Add(r.Info)
+			} else if info, err := agda.ReadHighlightingFile(r.Filepath); err != nil {
+				log.Printf("cannot read highlighting: %s", err)
+			} else {
+				doc.highlighting.Add(info)
+			}
+		case agda.Resp_ClearHighlighting:
+			doc.highlighting.Clear()
+		}
+		handle(r)
+	})
+}
+
+// Loads the file of uri and publishes its diagnostics.
+func (s *lspServer) load(uri string) {
+	doc, err := s.document(uri)
+	if err != nil {
+		log.Printf("cannot load: %s", err)
+		return
+	}
+	diagnostics := make(map[string][]diagnostic)
+	err = s.run(doc, request{Command: "load"}, func(r agda.Response) {
+		if info, ok := r.(agda.Resp_DisplayInfo); ok {
+			for _, d := range infoDiagnostics(info.Info, doc.file) {
+				diagnostics[d.File] = append(diagnostics[d.File], d)
+			}
+		}
+	})
+	if err != nil && err != errAgdaError {
+		s.showError(err)
+		return
+	}
+	var related []string
+	for file, fileDiagnostics := range diagnostics {
+		if file != doc.file {
+			related = append(related, fileURI(file))
+		}
+		s.publish(file, fileDiagnostics)
+	}
+	if _, ok := diagnostics[doc.file]; !ok {
+		s.publish(doc.file, nil)
+	}
+	s.Lock()
+	stale := doc.related
+	doc.related = related
+	s.Unlock()
+	for _, uri := range stale {
+		if file, err := uriFile(uri); err == nil {
+			if _, ok := diagnostics[file]; !ok {
+				s.publish(file, nil)
+			}
+		}
+	}
+}
+
+func (s *lspServer) publish(file string, diagnostics []diagnostic) {
+	text, err := s.text(file)
+	if err != nil {
+		log.Printf("cannot publish diagnostics: %s", err)
+	}
+	lspDiagnostics := []lspDiagnostic{}
+	for _, d := range diagnostics {
+		position := lineColPosition(text, d.Line, d.Col)
+		lspDiagnostics = append(lspDiagnostics, lspDiagnostic{
+			Range:    lspRange{position, position},
+			Severity: lspSeverities[d.Severity],
+			Source:   "agda",
+			Message:  d.Message,
+		})
+	}
+	s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+		"uri":         fileURI(file),
+		"diagnostics": lspDiagnostics,
+	})
+}
+
+// A goal in the text, as 0-based character offsets.
+type hole struct {
+	start, end int
+	// Without the surrounding {! !}
+	content string
+}
+
+// Like goalAddress, but matching one goal only if a line has several
+var goalRegexp = regexp.MustCompile(`(?m) \?( |$)|{!.*?!}`)
+
+// Returns the goals in text.
+func holes(text string) []hole {
+	var holes []hole
+	for _, m := range goalRegexp.FindAllStringIndex(text, -1) {
+		start, end := m[0], m[1]
+		goal := strings.TrimSpace(text[start:end])
+		start += strings.Index(text[start:end], goal)
+		end = start + len(goal)
+		content := ""
+		if strings.HasPrefix(goal, "{!") {
+			content = strings.TrimSpace(goal[2 : len(goal)-2])
+		}
+		holes = append(holes, hole{
+			start:   utf8.RuneCountInString(text[:start]),
+			end:     utf8.RuneCountInString(text[:end]),
+			content: content,
+		})
+	}
+	return holes
+}
+
+// Returns the interaction point of the goal at the 0-based character
+// offset q in doc, or the goal with the interaction point id if q is
+// negative.
+func (s *lspServer) goalAt(doc *lspDocument, q int, id uint) (uint, hole, bool) {
+	s.Lock()
+	defer s.Unlock()
+	goals := holes(doc.text)
+	if len(goals) != len(doc.goals) {
+		return 0, hole{}, false
+	}
+	for i, goal := range goals {
+		if q < 0 && doc.goals[i] == id || q >= goal.start && q <= goal.end {
+			return doc.goals[i], goal, true
+		}
+	}
+	return 0, hole{}, false
+}
+
+func (s *lspServer) codeActions(uri string, r lspRange) ([]lspCodeAction, error) {
+	doc, err := s.document(uri)
+	if err != nil {
+		return nil, err
+	}
+	actions := []lspCodeAction{}
+	id, _, ok := s.goalAt(doc, s.offset(doc, r.Start), 0)
+	if !ok {
+		return actions, nil
+	}
+	for _, action := range []struct{ title, command string }{
+		{"Give", "agda.give"},
+		{"Refine", "agda.refine"},
+		{"Case split", "agda.case"},
+	} {
+		actions = append(actions, lspCodeAction{
+			Title:   fmt.Sprintf("%s ?%d", action.title, id),
+			Kind:    "refactor.rewrite",
+			Command: lspCommand{Title: action.title, Command: action.command, Arguments: []interface{}{uri, id}},
+		})
+	}
+	return actions, nil
+}
+
+// Runs a command of a code action with the arguments URI and interaction
+// point, and applies the result to the text.
+func (s *lspServer) execute(command string, args []json.RawMessage) error {
+	var uri string
+	var id uint
+	if len(args) != 2 {
+		return lspError{lspInvalidParams, command + " expects a URI and a goal"}
+	} else if err := json.Unmarshal(args[0], &uri); err != nil {
+		return lspError{lspInvalidParams, err.Error()}
+	} else if err := json.Unmarshal(args[1], &id); err != nil {
+		return lspError{lspInvalidParams, err.Error()}
+	}
+	name, ok := lspCommands[command]
+	if !ok {
+		return lspError{lspInvalidParams, "unknown command " + command}
+	}
+	doc, err := s.document(uri)
+	if err != nil {
+		return err
+	}
+	_, goal, ok := s.goalAt(doc, -1, id)
+	if !ok {
+		return fmt.Errorf("goal ?%d is gone, reload the file", id)
+	}
+	req := request{Command: name, Args: []string{strconv.Itoa(int(id)), goal.content}}
+	var edits []lspTextEdit
+	var message bytes.Buffer
+	err = s.run(doc, req, func(r agda.Response) {
+		s.Lock()
+		text := doc.text
+		s.Unlock()
+		switch r := r.(type) {
+		case agda.Resp_GiveAction:
+			var result bytes.Buffer
+			req.write(&result, r)
+			edits = append(edits, lspTextEdit{
+				Range:   lspRange{positionOf(text, goal.start), positionOf(text, goal.end)},
+				NewText: strings.TrimSuffix(result.String(), "\n"),
+			})
+			s.Lock()
+			for i, g := range doc.goals {
+				if g == id {
+					doc.goals = append(doc.goals[:i:i], doc.goals[i+1:]...)
+					break
+				}
+			}
+			s.Unlock()
+		case agda.Resp_MakeCase:
+			start := positionOf(text, goal.start)
+			end := positionOf(text, goal.end)
+			edits = append(edits, lspTextEdit{
+				Range:   lspRange{lspPosition{start.Line, 0}, lspPosition{end.Line + 1, 0}},
+				NewText: strings.Join(r.Clauses, "\n") + "\n",
+			})
+			s.Lock()
+			doc.goals = nil // agda has to load the new clauses
+			s.Unlock()
+		case agda.Resp_DisplayInfo:
+			if isError(r) {
+				writeInfo(&message, r.Info)
+			}
+		}
+	})
+	if err == errAgdaError {
+		return errors.New(strings.TrimSpace(message.String()))
+	} else if err != nil {
+		return err
+	}
+	if len(edits) > 0 {
+		s.request("workspace/applyEdit", map[string]interface{}{
+			"label": command,
+			"edit":  map[string]interface{}{"changes": map[string][]lspTextEdit{uri: edits}},
+		})
+	}
+	return nil
+}
+
+// Returns the type of the goal or the name at position.
+func (s *lspServer) hover(uri string, position lspPosition) (interface{}, error) {
+	doc, err := s.document(uri)
+	if err != nil {
+		return nil, err
+	}
+	q := s.offset(doc, position)
+	var req request
+	var start, end int
+	if id, goal, ok := s.goalAt(doc, q, 0); ok {
+		req = request{Command: "type", Args: []string{strconv.Itoa(int(id))}}
+		start, end = goal.start, goal.end
+	} else {
+		s.Lock()
+		word, wordStart, wordEnd := wordAt(doc.text, q)
+		s.Unlock()
+		if word == "" {
+			return nil, nil
+		}
+		req = request{Command: "infer-toplevel", Args: []string{word}}
+		start, end = wordStart, wordEnd
+	}
+	var hover string
+	err = s.run(doc, req, func(r agda.Response) {
+		if info, ok := r.(agda.Resp_DisplayInfo); !ok {
+			return
+		} else if inferred, ok := info.Info.(agda.Info_InferredType); ok {
+			hover = fmt.Sprintf("%s : %s", req.Args[0], inferred.Expr)
+		} else if goal, ok := info.Info.(agda.Info_GoalSpecific); !ok {
+			return
+		} else if goalType, ok := goal.GoalInfo.(agda.Goal_GoalType); ok {
+			hover = fmt.Sprintf("?%d : %s", goal.InteractionPoint.Id, goalType.Type)
+		} else if inferred, ok := goal.GoalInfo.(agda.Goal_InferredType); ok {
+			hover = fmt.Sprintf("%s : %s", req.Args[0], inferred.Expr)
+		}
+	})
+	if err != nil && err != errAgdaError {
+		return nil, err
+	} else if hover == "" || err == errAgdaError {
+		return nil, nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	return map[string]interface{}{
+		"contents": map[string]string{"kind": "plaintext", "value": hover},
+		"range":    lspRange{positionOf(doc.text, start), positionOf(doc.text, end)},
+	}, nil
+}
+
+// Returns the definition site of the name at position, as highlighted by agda.
+func (s *lspServer) definition(uri string, position lspPosition) (interface{}, error) {
+	doc, err := s.document(uri)
+	if err != nil {
+		return nil, err
+	}
+	token, ok := doc.highlighting.At(s.offset(doc, position))
+	if !ok || token.DefinitionSite == nil {
+		return nil, nil
+	}
+	text, err := s.text(token.DefinitionSite.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	site := positionOf(text, token.DefinitionSite.Position-1)
+	return lspLocation{URI: fileURI(token.DefinitionSite.Filepath), Range: lspRange{site, site}}, nil
+}
+
+func (s *lspServer) offset(doc *lspDocument, position lspPosition) int {
+	s.Lock()
+	defer s.Unlock()
+	return offsetOf(doc.text, position)
+}
+
+// Returns the name around the 0-based character offset q and its range.
+func wordAt(text string, q int) (string, int, int) {
+	runes := []rune(text)
+	isName := func(r rune) bool {
+		return !unicode.IsSpace(r) && !strings.ContainsRune(`(){};."@`, r)
+	}
+	start, end := q, q
+	for start > 0 && start <= len(runes) && isName(runes[start-1]) {
+		start--
+	}
+	for end < len(runes) && isName(runes[end]) {
+		end++
+	}
+	if start >= end {
+		return "", q, q
+	}
+	return string(runes[start:end]), start, end
+}
+
+// Returns the 0-based character offset of position, which counts UTF-16
+// code units as the protocol does.
+func offsetOf(text string, position lspPosition) int {
+	line, units := 0, 0
+	q := 0
+	for _, r := range text {
+		if line == position.Line && units >= position.Character || line > position.Line {
+			break
+		}
+		if r == '\n' {
+			if line == position.Line {
+				break
+			}
+			line++
+		} else if line == position.Line {
+			units += utf16Len(r)
+		}
+		q++
+	}
+	return q
+}
+
+// Returns the position of the 0-based character offset q.
+func positionOf(text string, q int) lspPosition {
+	var position lspPosition
+	for i, r := range []rune(text) {
+		if i == q {
+			break
+		}
+		if r == '\n' {
+			position.Line++
+			position.Character = 0
+		} else {
+			position.Character += utf16Len(r)
+		}
+	}
+	return position
+}
+
+// Returns the position of the 1-based line and column agda reports.
+func lineColPosition(text string, line, col int) lspPosition {
+	position := lspPosition{Line: line - 1}
+	lines := strings.Split(text, "\n")
+	if line < 1 || line > len(lines) {
+		return position
+	}
+	for i, r := range []rune(lines[line-1]) {
+		if i == col-1 {
+			break
+		}
+		position.Character += utf16Len(r)
+	}
+	return position
+}
+
+func utf16Len(r rune) int {
+	if r > 0xFFFF {
+		return 2
+	}
+	return 1
+}
+
+func uriFile(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("%s is no file URI", uri)
+	}
+	return u.Path, nil
+}
+
+func fileURI(file string) string {
+	return (&url.URL{Scheme: "file", Path: file}).String()
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gitlab.com/neosimsim/acme-agda/agda"
+)
+
+const lspSource = `module Nat where
+
+data ℕ : Set where
+  zero : ℕ
+  suc  : ℕ → ℕ
+
+f : ℕ → ℕ
+f n = {! n !}
+`
+
+const lspURI = "file:///tmp/acme-agda-lsp/Nat.agda"
+
+const lspTranscript = `# agda 2.6.2
+< JSON>
+> load
+< {"kind":"ClearHighlighting","tokenBased":"NotOnlyTokenBased"}
+< {"kind":"HighlightingInfo","direct":true,"info":{"remove":false,"payload":[{"range":[69,70],"atoms":["datatype"],"tokenBased":"NotOnlyTokenBased","note":"","definitionSite":{"filepath":"/tmp/acme-agda-lsp/Nat.agda","position":24}}]}}
+< {"kind":"InteractionPoints","interactionPoints":[{"id":0,"range":[]}]}
+< {"kind":"DisplayInfo","info":{"kind":"AllGoalsWarnings","visibleGoals":[{"kind":"OfType","constraintObj":{"id":0,"range":[{"start":{"pos":83,"line":8,"col":7},"end":{"pos":90,"line":8,"col":14}}]},"type":"ℕ"}],"invisibleGoals":[],"warnings":[{"message":"/tmp/acme-agda-lsp/Nat.agda:3,6-7\nSomething to warn about."}],"errors":[]}}
+< JSON>
+> infer_toplevel
+< {"kind":"DisplayInfo","info":{"kind":"InferredType","commandState":{"interactionPoints":[],"currentFile":null},"time":null,"expr":"ℕ"}}
+< JSON>
+> case
+< {"kind":"MakeCase","variant":"Function","interactionPoint":{"id":0,"range":[]},"clauses":["f zero = ?","f (suc n) = ?"]}
+< JSON>
+`
+
+// The editor side of a language server under test.
+type lspClient struct {
+	t   *testing.T
+	in  io.Writer
+	out *textproto.Reader
+}
+
+type lspTestMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *lspError       `json:"error"`
+}
+
+func (c *lspClient) send(id int, method string, params interface{}) {
+	c.t.Helper()
+	message := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+	if id > 0 {
+		message["id"] = id
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%s", len(data), data); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// Skips the messages of the server up to the notification or request
+// method and decodes its parameters into v.
+func (c *lspClient) receive(method string, v interface{}) {
+	c.t.Helper()
+	for {
+		if message := c.next(); message.Method == method {
+			if err := json.Unmarshal(message.Params, v); err != nil {
+				c.t.Fatal(err)
+			}
+			return
+		}
+	}
+}
+
+// Skips the messages of the server up to the response to id and decodes
+// its result into v.
+func (c *lspClient) response(id int, v interface{}) {
+	c.t.Helper()
+	for {
+		if message := c.next(); message.Method == "" && string(message.ID) == strconv.Itoa(id) {
+			if message.Error != nil {
+				c.t.Fatalf("request %d failed: %s", id, message.Error.Message)
+			}
+			if err := json.Unmarshal(message.Result, v); err != nil {
+				c.t.Fatal(err)
+			}
+			return
+		}
+	}
+}
+
+func (c *lspClient) next() lspTestMessage {
+	c.t.Helper()
+	header, err := c.out.ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.out.R, body); err != nil {
+		c.t.Fatal(err)
+	}
+	var message lspTestMessage
+	if err := json.Unmarshal(body, &message); err != nil {
+		c.t.Fatal(err)
+	}
+	return message
+}
+
+func TestLSP(t *testing.T) {
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	s := &lspServer{
+		start: func(file string) (*agda.Client, error) {
+			return agda.Replay(strings.NewReader(lspTranscript), file)
+		},
+		out:       outWriter,
+		documents: make(map[string]*lspDocument),
+	}
+	done := make(chan error)
+	go func() { done <- s.serve(inReader) }()
+	c := &lspClient{t: t, in: inWriter, out: textproto.NewReader(bufio.NewReader(outReader))}
+	document := map[string]string{"uri": lspURI}
+
+	var initialized struct{ Capabilities map[string]interface{} }
+	c.send(1, "initialize", map[string]interface{}{})
+	c.response(1, &initialized)
+	if initialized.Capabilities["hoverProvider"] != true {
+		t.Errorf("capabilities %v lack hover", initialized.Capabilities)
+	}
+
+	c.send(0, "textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]string{"uri": lspURI, "languageId": "agda", "text": lspSource},
+	})
+	var published struct {
+		URI         string
+		Diagnostics []lspDiagnostic
+	}
+	c.receive("textDocument/publishDiagnostics", &published)
+	want := []lspDiagnostic{
+		{Range: lspRange{lspPosition{7, 6}, lspPosition{7, 6}}, Severity: 3, Source: "agda", Message: "?0 : ℕ"},
+		{Range: lspRange{lspPosition{2, 5}, lspPosition{2, 5}}, Severity: 2, Source: "agda", Message: "Something to warn about."},
+	}
+	if published.URI != lspURI || fmt.Sprint(published.Diagnostics) != fmt.Sprint(want) {
+		t.Errorf("published %s %v, want %v", published.URI, published.Diagnostics, want)
+	}
+
+	var location lspLocation
+	c.send(2, "textDocument/definition", map[string]interface{}{"textDocument": document, "position": lspPosition{6, 4}})
+	c.response(2, &location)
+	if want := (lspLocation{lspURI, lspRange{lspPosition{2, 5}, lspPosition{2, 5}}}); location != want {
+		t.Errorf("definition at %v, want %v", location, want)
+	}
+
+	var hover struct{ Contents struct{ Value string } }
+	c.send(3, "textDocument/hover", map[string]interface{}{"textDocument": document, "position": lspPosition{3, 4}})
+	c.response(3, &hover)
+	if want := "zero : ℕ"; hover.Contents.Value != want {
+		t.Errorf("hover shows %q, want %q", hover.Contents.Value, want)
+	}
+
+	var actions []lspCodeAction
+	c.send(4, "textDocument/codeAction", map[string]interface{}{
+		"textDocument": document,
+		"range":        lspRange{lspPosition{7, 9}, lspPosition{7, 9}},
+		"context":      map[string]interface{}{"diagnostics": []interface{}{}},
+	})
+	c.response(4, &actions)
+	if len(actions) != 3 || actions[2].Command.Command != "agda.case" {
+		t.Fatalf("code actions %v, want give, refine and case", actions)
+	}
+
+	var applyEdit struct {
+		Edit struct{ Changes map[string][]lspTextEdit }
+	}
+	c.send(5, "workspace/executeCommand", map[string]interface{}{
+		"command":   actions[2].Command.Command,
+		"arguments": actions[2].Command.Arguments,
+	})
+	c.receive("workspace/applyEdit", &applyEdit)
+	wantEdits := []lspTextEdit{{lspRange{lspPosition{7, 0}, lspPosition{8, 0}}, "f zero = ?\nf (suc n) = ?\n"}}
+	if edits := applyEdit.Edit.Changes[lspURI]; fmt.Sprint(edits) != fmt.Sprint(wantEdits) {
+		t.Errorf("case split edits %v, want %v", edits, wantEdits)
+	}
+	var result interface{}
+	c.response(5, &result)
+
+	c.send(6, "shutdown", nil)
+	c.response(6, &result)
+	c.send(0, "exit", nil)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	text := "a\n𝔸b\nc"
+	for _, test := range []struct {
+		q        int
+		position lspPosition
+	}{
+		{0, lspPosition{0, 0}},
+		{2, lspPosition{1, 0}},
+		{3, lspPosition{1, 2}},
+		{4, lspPosition{1, 3}},
+		{5, lspPosition{2, 0}},
+	} {
+		if position := positionOf(text, test.q); position != test.position {
+			t.Errorf("position of %d is %v, want %v", test.q, position, test.position)
+		}
+		if q := offsetOf(text, test.position); q != test.q {
+			t.Errorf("offset of %v is %d, want %d", test.position, q, test.q)
+		}
+	}
+}
+
+func TestReadMalformedLSPMessage(t *testing.T) {
+	for _, header := range []string{
+		"Content-Length: -1\r\n\r\n",
+		"Content-Length: 1099511627776\r\n\r\n{}",
+		"Content-Length: many\r\n\r\n{}",
+		"Content-Type: application/json\r\n\r\n{}",
+	} {
+		reader := textproto.NewReader(bufio.NewReader(strings.NewReader(header)))
+		if _, err := readLSPMessage(reader); err == nil || err == io.EOF {
+			t.Errorf("read %q without error", header)
+		}
+	}
+}
+
+func TestHoverReportsFailures(t *testing.T) {
+	s := &lspServer{
+		start: func(file string) (*agda.Client, error) {
+			return nil, errors.New("agda not found")
+		},
+		documents: map[string]*lspDocument{
+			lspURI: {uri: lspURI, file: "/tmp/acme-agda-lsp/Nat.agda", text: lspSource},
+		},
+	}
+	if result, err := s.hover(lspURI, lspPosition{3, 4}); err == nil {
+		t.Errorf("hover is %v without error", result)
+	}
+}
@@ -1,21 +1,66 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
+
+	"9fans.net/go/acme"
+	"9fans.net/go/plumb"
+	"gitlab.com/neosimsim/acme-agda/agda"
 )
 
 var (
-	agdaCmd = flag.String("with-agda", "agda", "Name or path of the agda compiler")
-	debug   = flag.Bool("v", false, "Enable verbose debugging output")
+	agdaCmd    = flag.String("with-agda", "", "Name or path of the agda compiler (default \"agda\")")
+	debug      = flag.Bool("v", false, "Enable verbose debugging output")
+	serveDir   = flag.Bool("d", false, "Serve all Agda windows below the current directory with one agda process")
+	input      = flag.Bool("input", false, "Translate the input sequence before dot in the calling window, e.g. \\to to →, and exit")
+	recordFile = flag.String("record", "", "Record the session with agda to `file`")
+	replayFile = flag.String("replay", "", "Replay the session recorded in `file` instead of running agda")
+	lookup     = flag.Bool("lookup", false, "Print the input sequences starting with the arguments or the sequence before dot and exit")
+	configPath = flag.String("config", DefaultConfigPath(), "Path of the configuration file")
+	jsonOutput = flag.Bool("json", false, "Print the results of subcommands and -check as JSON")
+	checkFiles = flag.Bool("check", false, "Load the Agda files given as arguments, print their errors, warnings and goals and exit")
+	lspMode    = flag.Bool("lsp", false, "Serve the Language Server Protocol on stdin and stdout")
+	agdaFlags  stringList
+	loadFlags  stringList
+	includes   stringList
+	libraries  stringList
 )
 
+func init() {
+	flag.Var(&agdaFlags, "agda-flag", "Extra `argument` passed to agda, may be repeated")
+	flag.Var(&loadFlags, "load-flag", "`Option` passed to agda when loading the file, e.g. --safe, may be repeated")
+	flag.Var(&includes, "i", "Include `dir`ectory passed to agda when loading the file, may be repeated")
+	flag.Var(&libraries, "library", "Agda `library` used when loading the file, may be repeated")
+}
+
 const usageFmt = `Usage of %s:
 
 Run this command from an Agda file opened in Acme.
+With -d, run it from a directory to serve all Agda files below it.
+With -input or -lookup, run it from the tag of an Agda file to enter
+Unicode symbols.
+
+	%[1]s [flags] load [file]
+	%[1]s [flags] goals [file]
+	%[1]s [flags] give|refine goal expression [file]
+	%[1]s [flags] case goal variable [file]
+
+run the command with the running acme-agda, or with agda for the file
+if there is none, and print the results.
+
+	%[1]s -check [-json] file...
+
+prints the errors, warnings and goals of the files and fails on errors.
+
+	%[1]s -lsp
+
+serves the Language Server Protocol on stdin and stdout for other editors.
 
 Not all of the Agda interaction mode is supported yet.
 Goal selection does not work on edge cases, either.
@@ -28,6 +73,52 @@ func main() {
 		flag.PrintDefaults()
 	}
 	flag.Parse()
+	agda.Debugf = debugPrint
+	if *input || *lookup {
+		if err := inputMethod(); err != nil {
+			log.Fatalf("%s\n", err)
+		}
+		return
+	}
+	if *checkFiles {
+		if flag.NArg() == 0 {
+			log.Fatalf("-check expects the Agda files to check\n")
+		}
+		if err := check(flag.Args(), os.Stdout, *jsonOutput); err == errAgdaError {
+			os.Exit(1)
+		} else if err != nil {
+			log.Fatalf("%s\n", err)
+		}
+		return
+	}
+	if *lspMode {
+		if err := serveLSP(os.Stdin, os.Stdout); err != nil {
+			log.Fatalf("%s\n", err)
+		}
+		return
+	}
+	if flag.NArg() > 0 {
+		if err := runSubcommand(flag.Args(), os.Stdout); err != nil {
+			log.Fatalf("%s\n", err)
+		}
+		return
+	}
+	if *serveDir {
+		dir, err := os.Getwd()
+		if err != nil {
+			log.Fatalf("cannot get current directory: %s\n", err)
+		}
+		config, err := loadConfig(dir)
+		if err != nil {
+			log.Fatalf("cannot read configuration: %s\n", err)
+		}
+		closeLog := setupLog(config)
+		defer closeLog()
+		if err := serveDirectory(dir, config); err != nil {
+			log.Fatalf("%s\n", err)
+		}
+		return
+	}
 	if editWin, err := CallingWindow(); err != nil {
 		log.Fatalf("cannot determine calling window: %v\n", err)
 	} else {
@@ -37,43 +128,299 @@ func main() {
 		if agdaFile, err := WindowName(editWin); err != nil {
 			log.Fatalf("cannot determine calling window: %s\n", err)
 		} else {
-			if a, err := NewAgda(*agdaCmd, agdaFile); err != nil {
+			config, err := loadConfig(filepath.Dir(agdaFile))
+			if err != nil {
+				log.Fatalf("cannot read configuration: %s\n", err)
+			}
+			closeLog := setupLog(config)
+			defer closeLog()
+			if a, err := startAgda(config, agdaFile); err != nil {
 				log.Fatalf("unable to start agda: %s", err)
 			} else {
-				if menu, err := NewMenu(a, editWin); err != nil {
+				if menu, err := openMenu(a, editWin, config); err != nil {
 					log.Fatalf("cannot open acme menu: %s\n", err)
 				} else {
-					defer menu.Close()
-					menu.Redraw()
+					if session, err := serveSession(func() *agda.Client { return a }); err != nil {
+						log.Printf("cannot serve subcommands: %s", err)
+					} else {
+						defer session.Close()
+					}
+					if files, err := serveFiles(func() *agda.Client { return a }); err != nil {
+						log.Printf("cannot serve files: %s", err)
+					} else {
+						defer files.Close()
+					}
 					go func() {
-						for r := range a.Responses() {
-							log.Printf("response: %T%v", r, r)
-							switch r.(type) {
-							case Resp_MakeCase:
-								debugPrint("response %T%v", r, r)
-								SelectCurrentLine(editWin)
-								ReplaceSelection(editWin, fmt.Sprintf("%s\n", strings.Join(r.(Resp_MakeCase).Clauses, "\n")))
-							case Resp_DisplayInfo:
-								debugPrint("response %T%v", r, r)
-								menu.DisplayInfo = r.(Resp_DisplayInfo).Info
-								menu.Error = nil
-								menu.Redraw()
-							case Resp_GiveAction:
-								debugPrint("response %T%v", r, r)
-							case Resp_JumpToError:
-								debugPrint("response %T%v", r, r)
-							default:
-								debugPrint("unknown response: %T %v", r, r)
+						err := watchLog(func(event acme.LogEvent) {
+							if event.ID != editWin.ID() {
+								return
 							}
-						}
+							switch event.Op {
+							case "put":
+								menu.Put()
+							case "del":
+								if err := menu.Delete(); err != nil {
+									log.Printf("failed to delete the menu window: %s", err)
+								}
+							}
+						})
+						log.Printf("stopped watching the acme log: %s", err)
+					}()
+					go func() {
+						err := ListenPlumb(definitionPort, func(message *plumb.Message) {
+							showDefinition(menu, string(message.Data))
+						})
+						debugPrint("stopped listening for definitions: %s", err)
+					}()
+					go func() {
+						err := ListenPlumb(namePort, func(message *plumb.Message) {
+							explainName(menu, message)
+						})
+						debugPrint("stopped listening for names: %s", err)
 					}()
 					menu.Loop()
+					menu.Close()
+					if err := a.Exit(); err != nil {
+						log.Printf("agda exited: %s", err)
+					}
 				}
 			}
 		}
 	}
 }
 
+// Starts agda for agdaFile, or replays a transcript if requested.
+func startAgda(config *Config, agdaFile string) (*agda.Client, error) {
+	var a *agda.Client
+	if *replayFile != "" {
+		transcript, err := os.Open(*replayFile)
+		if err != nil {
+			return nil, err
+		}
+		if a, err = agda.Replay(transcript, agdaFile); err != nil {
+			transcript.Close()
+			return nil, err
+		}
+	} else {
+		var err error
+		if a, err = agda.Start(config.Agda, agdaFile, config.AgdaFlags, config.LoadFlags); err != nil {
+			return nil, err
+		}
+	}
+	if *recordFile != "" {
+		transcript, err := os.Create(*recordFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.Record(transcript); err != nil {
+			transcript.Close()
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Opens the menu for the Agda file in editWin and starts handling the
+// responses of a.
+func openMenu(a *agda.Client, editWin Window, config *Config) (*Menu, error) {
+	menu, err := NewMenu(a, editWin)
+	if err != nil {
+		return nil, err
+	}
+	a.SetIndirectHighlighting(config.IndirectHighlighting)
+	log.Printf("using agda %s", a.Version())
+	if lib, err := FindAgdaLib(a.Filename()); err != nil {
+		debugPrint("%s: %s", a.Filename(), err)
+	} else {
+		log.Printf("%s belongs to library %s", a.Filename(), lib)
+		menu.Library = lib
+	}
+	if err := menu.Configure(config); err != nil {
+		if err := menu.Delete(); err != nil {
+			log.Printf("failed to delete the menu window: %s", err)
+		}
+		menu.Close()
+		return nil, err
+	}
+	menu.TagCommands = config.TagCommands
+	menu.Redraw()
+	go handleResponses(a, menu, editWin)
+	if len(menu.TagCommands) > 0 {
+		go menu.LoopTag()
+	}
+	if config.AutoReloads("start") {
+		if err := a.LoadFile(); err != nil {
+			log.Printf("could not load file: %s", err)
+		}
+	}
+	return menu, nil
+}
+
+// Handles the responses to the menu's commands until a is closed.
+func handleResponses(a *agda.Client, menu *Menu, editWin Window) {
+	highlighting := make(chan agda.Response, 64)
+	defer close(highlighting)
+	go highlight(menu, highlighting)
+	for {
+		var r agda.Response
+		select {
+		case r = <-a.Responses():
+		case <-a.Done():
+			return
+		}
+		log.Printf("response: %T%v", r, r)
+		switch r.(type) {
+		case agda.Resp_MakeCase:
+			debugPrint("response %T%v", r, r)
+			SelectCurrentLine(editWin)
+			ReplaceSelection(editWin, fmt.Sprintf("%s\n", strings.Join(r.(agda.Resp_MakeCase).Clauses, "\n")))
+		case agda.Resp_DisplayInfo:
+			debugPrint("response %T%v", r, r)
+			menu.ShowInfo(r.(agda.Resp_DisplayInfo).Info)
+		case agda.Resp_HighlightingInfo, agda.Resp_ClearHighlighting:
+			highlighting <- r
+		case agda.Resp_InteractionPoints:
+			menu.SetGoals(r.(agda.Resp_InteractionPoints).InteractionPoints)
+		case agda.Resp_Prompt:
+			debugPrint("agda completed a command")
+		case agda.Resp_Exited:
+			menu.ShowError(errors.New("agda exited unexpectedly, see +Errors"))
+			acme.Err(a.Filename(), "agda exited:\n"+r.(agda.Resp_Exited).Stderr)
+		case agda.Resp_InternalError:
+			menu.ShowError(errors.New("agda reported an internal error, see +Errors"))
+			acme.Err(a.Filename(), r.(agda.Resp_InternalError).Stderr)
+		case agda.Resp_GiveAction:
+			debugPrint("response %T%v", r, r)
+			give := r.(agda.Resp_GiveAction)
+			if idx, err := menu.giveGoal(give.InteractionPoint.Id); err != nil {
+				log.Printf("could not give: %s", err)
+			} else if err := GiveGoal(editWin, idx, give.GiveResult); err != nil {
+				log.Printf("could not give: %s", err)
+			}
+		case agda.Resp_JumpToError:
+			debugPrint("response %T%v", r, r)
+			if err := PlumbEdit(r.(agda.Resp_JumpToError).Filepath, r.(agda.Resp_JumpToError).Position); err != nil {
+				log.Printf("could not show error: %s", err)
+			}
+		default:
+			debugPrint("unknown response: %T %v", r, r)
+		}
+	}
+}
+
+// Applies the highlighting responses to the menu in order, so that a
+// later ClearHighlighting cannot be overtaken. Reading the highlighting
+// files here keeps them from holding up the other responses.
+func highlight(menu *Menu, responses <-chan agda.Response) {
+	for r := range responses {
+		switch r := r.(type) {
+		case agda.Resp_HighlightingInfo:
+			if r.Direct {
+				menu.Highlighting.Add(r.Info)
+			} else if info, err := agda.ReadHighlightingFile(r.Filepath); err != nil {
+				log.Printf("cannot read highlighting: %s", err)
+			} else {
+				menu.Highlighting.Add(info)
+			}
+		case agda.Resp_ClearHighlighting:
+			menu.Highlighting.Clear()
+		}
+	}
+}
+
+// Runs the input method on the calling window for -input and -lookup.
+func inputMethod() error {
+	if *lookup && flag.NArg() > 0 {
+		for _, prefix := range flag.Args() {
+			fmt.Println(strings.Join(LookupInput(prefix), "\n"))
+		}
+		return nil
+	}
+	editWin, err := CallingWindow()
+	if err != nil {
+		return fmt.Errorf("cannot determine calling window: %w", err)
+	}
+	if err := ResetAddr(editWin); err != nil {
+		return err
+	}
+	if *input {
+		return ExpandInput(editWin)
+	}
+	seq, _, _, err := InputBeforeDot(editWin)
+	if err != nil {
+		return err
+	}
+	fmt.Println(strings.Join(LookupInput(seq), "\n"))
+	return nil
+}
+
+// Plumb port on which acme-agda receives names to show the definition of
+const definitionPort = "agdadef"
+
+func showDefinition(menu *Menu, name string) {
+	if site, err := menu.DefinitionOf(name); err != nil {
+		log.Printf("cannot find definition: %s", err)
+	} else if err := PlumbEdit(site.Filepath, site.Position); err != nil {
+		log.Printf("could not show definition: %s", err)
+	}
+}
+
+// Plumb port on which acme-agda receives names to show the type of or,
+// with the attribute cmd=why, to explain why they are in scope
+const namePort = "agda"
+
+func explainName(menu *Menu, message *plumb.Message) {
+	name := strings.TrimSpace(string(message.Data))
+	var cmd agda.Command = agda.Cmd_infer_toplevel{Rewrite: menu.rewrite(), Expr: name}
+	if message.LookupAttr("cmd") == "why" {
+		cmd = agda.Cmd_why_in_scope_toplevel{Name: name}
+	}
+	if err := menu.agdaInteraction.Send(cmd); err != nil {
+		log.Printf("could not explain %s: %s", name, err)
+	}
+}
+
+// Redirects the log to the file configured, if any.
+// The returned function closes the log file.
+func setupLog(config *Config) func() {
+	if config.Log == "" {
+		return func() {}
+	}
+	logFile, err := os.OpenFile(config.Log, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Fatalf("cannot open log file: %s\n", err)
+	}
+	log.SetOutput(logFile)
+	return func() { logFile.Close() }
+}
+
+// Reads the global and the project configuration file for the Agda files
+// in dir and applies the command line flags on top of them.
+func loadConfig(dir string) (*Config, error) {
+	config := DefaultConfig()
+	if *configPath != "" {
+		if err := config.ReadFile(*configPath); err != nil {
+			return nil, err
+		}
+	}
+	if projectConfig, err := FindProjectConfig(dir); err != nil {
+		return nil, err
+	} else if projectConfig != "" {
+		debugPrint("using project configuration %s", projectConfig)
+		if err := config.ReadFile(projectConfig); err != nil {
+			return nil, err
+		}
+	}
+	if *agdaCmd != "" {
+		config.Agda = *agdaCmd
+	}
+	config.set("agdaflag", agdaFlags)
+	config.set("loadflag", loadFlags)
+	config.set("include", includes)
+	config.set("library", libraries)
+	return config, nil
+}
+
 func debugPrint(f string, vals ...interface{}) {
 	if *debug {
 		log.Printf("DEBUG: "+f, vals...)
@@ -3,63 +3,243 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
+	"unicode"
 
 	"9fans.net/go/acme"
+	"gitlab.com/neosimsim/acme-agda/agda"
 )
 
-const menuText = `Get Case Refine Next Goal
-
+const menuText = `{{ join .Commands " " }}
+{{ with .Library }}Library: {{ . }}
+{{ end }}
 {{ template "displayInfo" .DisplayInfo}}
 {{ with .Error }}{{ .Error }}{{ end }}
-
-{{ define "displayInfo" }}{{ with .Goals}}Goals:
-{{ . }}{{ end }}{{ with .Warnings}}Warnings:
-{{ . }}{{ end }}{{ with .Errors}}Errors:
-{{ . }}{{ end }}{{ with .Message}}Message:
-{{ . }}{{ end }}{{ with .Payload}}Payload:
-{{ . }}{{ end }}{{ end }}
+{{ with .Symbols }}Symbols:
+{{ join . "\n" }}
+{{ end }}
+{{ define "displayInfo" }}{{ with field . "Goals"}}Goals:
+{{ . }}{{ end }}{{ with field . "VisibleGoals"}}Goals:
+{{ range . }}{{ .ConstraintObj }} : {{ .Type }}
+{{ end }}{{ end }}{{ with field . "Warnings"}}Warnings:
+{{ . }}{{ end }}{{ with field . "Errors"}}Errors:
+{{ . }}{{ end }}{{ with field . "Message"}}Message:
+{{ . }}{{ end }}{{ with field . "Expr"}}Type: {{ . }}
+{{ end }}{{ with field . "Payload"}}Payload:
+{{ . }}{{ end }}{{ with field . "GoalInfo"}}{{ with field . "Type"}}Goal: {{ . }}
+{{ end }}{{ end }}{{ end }}
 `
 
-type Menu struct {
-	menuWin         *acme.Win
-	agdaWin         *acme.Win
-	template        *template.Template
-	agdaInteraction *Agda
-	DisplayInfo     DisplayInfo
-	Error           error
+var menuFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"field": field,
 }
 
-func NewMenu(agdaInteraction *Agda, agdaWin *acme.Win) (*Menu, error) {
-	var menu Menu
-	var err error
-	if menu.template, err = template.New("menu").Parse(menuText); err != nil {
-		return nil, errors.Unwrap(fmt.Errorf("cannot parse menu templates: %w", err))
+// The placeholders of the user's commands
+type userCommand struct {
+	Goal    int
+	Content string
+	File    string
+}
+
+var userCommandFuncs = template.FuncMap{
+	"quote": agda.Quote,
+}
+
+// Returns the field name of the struct v, or nil if v has no such field.
+// Lets the menu template handle the different DisplayInfo kinds alike.
+func field(v interface{}, name string) interface{} {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+	if f := value.FieldByName(name); f.IsValid() {
+		return f.Interface()
 	}
-	if menu.menuWin, err = acme.New(); err != nil {
+	return nil
+}
+
+type Menu struct {
+	menuWin         Window
+	agdaWin         Window
+	agdaInteraction *agda.Client
+	// Commands added to the tag of the Agda window, read when it is opened
+	TagCommands []string
+	Library     *AgdaLib
+	// Highlighting of the Agda file, to find definitions
+	Highlighting Highlighting
+
+	goalsMu sync.Mutex
+	// Interaction point ids of the goals in the order of the text, as
+	// of the last InteractionPoints response
+	goals []uint
+
+	// Guards what Redraw shows and the settings Configure changes, as
+	// responses arrive and the menu may be reconfigured while commands run
+	configMu    sync.RWMutex
+	DisplayInfo agda.DisplayInfo
+	Error       error
+	// Result of the last Lookup
+	Symbols  []string
+	template *template.Template
+	Commands []string
+	// IOTCM templates of the user's commands by name
+	UserCommands map[string]*template.Template
+	Rewrite      agda.Rewrite
+	// Reload the file whenever the Agda window is put
+	ReloadOnPut bool
+	ReloadDelay time.Duration
+	AbortReload bool
+
+	reloadMu    sync.Mutex
+	reloadTimer *time.Timer
+}
+
+// The menu shown by default and if the user's template fails
+var defaultTemplate = template.Must(template.New("menu").Funcs(menuFuncs).Parse(menuText))
+
+func NewMenu(agdaInteraction *agda.Client, agdaWin Window) (*Menu, error) {
+	menuWin, err := acme.New()
+	if err != nil {
 		return nil, errors.Unwrap(fmt.Errorf("cannot open new acme menuWindow: %w", err))
 	}
 	currentWorkingDirectory, err := os.Getwd()
 	if err != nil {
 		return nil, errors.Unwrap(fmt.Errorf("cannot get current directory: %w", err))
 	}
-	if err = menu.menuWin.Name("%s+Acme", currentWorkingDirectory); err != nil {
+	if err = menuWin.Name("%s+Acme", currentWorkingDirectory); err != nil {
 		return nil, errors.Unwrap(fmt.Errorf("cannot set acme menuWindow name: %w", err))
 	}
+	return newMenu(agdaInteraction, agdaWin, menuWin)
+}
+
+// Returns the menu for agdaWin shown in menuWin.
+func newMenu(agdaInteraction *agda.Client, agdaWin, menuWin Window) (*Menu, error) {
+	var menu Menu
+	menu.template = defaultTemplate
+	menu.menuWin = menuWin
 	menu.agdaInteraction = agdaInteraction
+	menu.Commands = defaultMenuCommands
+	menu.Rewrite = "Simplified"
 	menu.agdaWin = agdaWin
 	return &menu, nil
 }
 
+// Applies the settings of config to the menu, except the tag commands,
+// which only take effect when the Agda window is opened.
+func (menu *Menu) Configure(config *Config) error {
+	menuTemplate := defaultTemplate
+	if config.Template != "" {
+		data, err := ioutil.ReadFile(config.Template)
+		if err != nil {
+			return fmt.Errorf("cannot read menu template: %w", err)
+		}
+		if menuTemplate, err = template.New("menu").Funcs(menuFuncs).Parse(string(data)); err != nil {
+			return fmt.Errorf("cannot parse menu template: %w", err)
+		}
+	}
+	userCommands := make(map[string]*template.Template)
+	for name, iotcm := range config.Commands {
+		var err error
+		if userCommands[name], err = template.New(name).Funcs(userCommandFuncs).Parse(iotcm); err != nil {
+			return fmt.Errorf("cannot parse command %s: %w", name, err)
+		}
+	}
+	menu.configMu.Lock()
+	defer menu.configMu.Unlock()
+	menu.template = menuTemplate
+	menu.UserCommands = userCommands
+	menu.Commands = config.MenuCommands
+	menu.Rewrite = config.Rewrite
+	menu.ReloadOnPut = config.AutoReloads("put")
+	menu.ReloadDelay = config.ReloadDelay
+	menu.AbortReload = config.AbortReload
+	return nil
+}
+
+// Returns the normalisation configured.
+func (menu *Menu) rewrite() agda.Rewrite {
+	menu.configMu.RLock()
+	defer menu.configMu.RUnlock()
+	return menu.Rewrite
+}
+
+// Returns the IOTCM template of the user's command name, if any.
+func (menu *Menu) userCommand(name string) (*template.Template, bool) {
+	menu.configMu.RLock()
+	defer menu.configMu.RUnlock()
+	iotcm, ok := menu.UserCommands[name]
+	return iotcm, ok
+}
+
+// Sends the user's command name, selecting the goal at dot if the
+// command refers to it.
+func (menu *Menu) sendUserCommand(name string, iotcm *template.Template) error {
+	placeholders := userCommand{Goal: -1, File: menu.agdaInteraction.Filename()}
+	if source := iotcm.Root.String(); strings.Contains(source, ".Goal") || strings.Contains(source, ".Content") {
+		var err error
+		if placeholders.Goal, placeholders.Content, err = menu.selectedGoal(); err != nil {
+			return err
+		}
+	}
+	var line strings.Builder
+	if err := iotcm.Execute(&line, placeholders); err != nil {
+		return fmt.Errorf("cannot fill in command %s: %w", name, err)
+	}
+	return menu.agdaInteraction.SendIOTCM(line.String())
+}
+
+func (menu *Menu) Name(name string) error {
+	return menu.menuWin.Name("%s", name)
+}
+
+// Shows info, replacing the error shown.
+func (menu *Menu) ShowInfo(info agda.DisplayInfo) {
+	menu.configMu.Lock()
+	menu.DisplayInfo, menu.Error = info, nil
+	menu.configMu.Unlock()
+	menu.Redraw()
+}
+
+// Shows err, or no error if it is nil.
+func (menu *Menu) ShowError(err error) {
+	menu.configMu.Lock()
+	menu.Error = err
+	menu.configMu.Unlock()
+	menu.Redraw()
+}
+
+// Shows the symbols found by Lookup.
+func (menu *Menu) ShowSymbols(symbols []string) {
+	menu.configMu.Lock()
+	menu.Symbols = symbols
+	menu.configMu.Unlock()
+	menu.Redraw()
+}
+
 func (menu *Menu) Redraw() {
 	if err := menu.menuWin.Addr(","); err != nil {
 		log.Printf("error writing display address: %s", err)
 	} else {
 		var builder strings.Builder
-		menu.template.Execute(&builder, menu)
+		menu.configMu.RLock()
+		if err := menu.template.Execute(&builder, menu); err != nil {
+			// Show the default menu instead of a truncated one.
+			builder.Reset()
+			fmt.Fprintf(&builder, "cannot show menu template: %s\n\n", err)
+			if err := defaultTemplate.Execute(&builder, menu); err != nil {
+				log.Printf("cannot show menu: %s", err)
+			}
+		}
+		menu.configMu.RUnlock()
 		if _, err := menu.menuWin.Write("data", []byte(builder.String())); err != nil {
 			log.Printf("error writing display info: %s", err)
 		} else {
@@ -75,95 +255,299 @@ func (menu *Menu) Redraw() {
 
 func (menu *Menu) Loop() {
 	for e := range menu.menuWin.EventChan() {
-		go func(event *acme.Event) {
-			switch event.C2 {
-			case 'x', 'X':
-				switch string(event.Text) {
-				case "Del":
-					if err := menu.menuWin.Ctl("delete"); err != nil {
-						log.Fatalln("Failed to delete the menuWindow:", err)
-					}
-					os.Exit(0)
-				case "Get":
-					if err := menu.agdaWin.Ctl("put"); err != nil {
-						log.Printf("could save file: %s", err)
-					}
-					if err := menu.agdaInteraction.LoadFile(); err != nil {
-						log.Printf("could not load file: %s", err)
-					}
-				case "Case":
-					if err := SelectGoal(menu.agdaWin); err != nil {
-						log.Printf("could not select goal: %s", err)
-						return
-					}
-					start, end, err := menu.agdaWin.ReadAddr()
-					if err != nil {
-						log.Printf("could not read select goal address: %s", err)
-						return
-					}
-					goalRanges, err := GoalRanges(menu.agdaWin)
-					if err != nil {
-						log.Printf("could not get goal rages: %s", err)
-						return
-					}
-					goalIdx := -1
-					for i, goalRange := range goalRanges {
-						if goalRange.Start == start && goalRange.End == end {
-							goalIdx = i
-							break
-						}
-					}
-					if goalIdx == -1 {
-						log.Printf("move dot inside a goal")
-					}
-					goalContent := menu.agdaWin.Selection()
-					goalContent = goalContent[2 : len(goalContent)-2] // drop {! and !}
-					if err := menu.agdaInteraction.CaseSplit(goalIdx, goalContent); err != nil {
-						log.Printf("could not load file: %s", err)
-					}
-				case "Refine":
-					if err := SelectGoal(menu.agdaWin); err != nil {
-						log.Printf("could not select goal: %s", err)
-						return
-					}
-					start, end, err := menu.agdaWin.ReadAddr()
-					if err != nil {
-						log.Printf("could not read select goal address: %s", err)
-						return
-					}
-					goalRanges, err := GoalRanges(menu.agdaWin)
-					if err != nil {
-						log.Printf("could not get goal rages: %s", err)
-						return
-					}
-					goalIdx := -1
-					for i, goalRange := range goalRanges {
-						if goalRange.Start == start && goalRange.End == end {
-							goalIdx = i
-							break
-						}
-					}
-					if goalIdx == -1 {
-						log.Printf("move dot inside a goal")
-					}
-					goalContent := menu.agdaWin.Selection()
-					goalContent = goalContent[2 : len(goalContent)-2] // drop {! and !}
-					if err := menu.agdaInteraction.RefineHole(goalIdx, goalContent); err != nil {
-						log.Printf("could not load file: %s", err)
-					}
-				case "Next":
-					NextGoal(menu.agdaWin)
-				case "Goal":
-					ReplaceSelection(menu.agdaWin, "{!!}")
-				default:
-					menu.menuWin.WriteEvent(event)
-				}
+		go menu.execute(e)
+	}
+}
+
+// Commands of Acme, which are left to Acme when they are in the tag of
+// the Agda window
+var acmeCommands = map[string]bool{
+	"Cut": true, "Del": true, "Delcol": true, "Delete": true, "Dump": true,
+	"Edit": true, "Exit": true, "Font": true, "Get": true, "ID": true,
+	"Incl": true, "Indent": true, "Kill": true, "Load": true, "Local": true,
+	"Look": true, "New": true, "Newcol": true, "Paste": true, "Put": true,
+	"Putall": true, "Redo": true, "Send": true, "Snarf": true, "Sort": true,
+	"Tab": true, "Undo": true, "Zerox": true,
+}
+
+// Commands of the menu, which the user's commands cannot replace
+var menuCommands = map[string]bool{
+	"Case": true, "Config": true, "Def": true, "Del": true, "Get": true,
+	"Give": true, "Goal": true, "Input": true, "Lookup": true, "Next": true,
+	"Refine": true, "Type": true,
+}
+
+// Adds TagCommands to the tag of the Agda window and runs them when they
+// are executed in the Agda window. Everything else is passed back to Acme.
+// Returns when the Agda window is closed.
+func (menu *Menu) LoopTag() {
+	if _, err := menu.agdaWin.Write("tag", []byte(" "+strings.Join(menu.TagCommands, " "))); err != nil {
+		log.Printf("cannot write the tag: %s", err)
+	}
+	for e := range menu.agdaWin.EventChan() {
+		if menu.isTagCommand(e) {
+			go menu.execute(e)
+		} else if strings.ContainsRune("xXlL", rune(e.C2)) { // Acme rejects the other events
+			if err := menu.agdaWin.WriteEvent(e); err != nil {
+				log.Printf("cannot pass event back to acme: %s", err)
+			}
+		}
+	}
+}
+
+func (menu *Menu) isTagCommand(e *acme.Event) bool {
+	if e.C2 != 'x' && e.C2 != 'X' {
+		return false
+	}
+	fields := strings.Fields(string(e.Text))
+	if len(fields) == 0 || acmeCommands[fields[0]] {
+		return false
+	}
+	for _, c := range menu.TagCommands {
+		if c == fields[0] {
+			return true
+		}
+	}
+	return false
+}
 
-			default:
+// Handles an event of the menu window.
+func (menu *Menu) execute(event *acme.Event) {
+	switch event.C2 {
+	case 'x', 'X':
+		cmd, arg := strings.TrimSpace(string(event.Text)), string(event.Arg)
+		if i := strings.IndexFunc(cmd, unicode.IsSpace); i >= 0 {
+			cmd, arg = cmd[:i], strings.TrimSpace(cmd[i:])
+		}
+		switch cmd {
+		case "Del":
+			if err := menu.Delete(); err != nil {
+				log.Printf("failed to delete the menu window: %s", err)
+			}
+		case "Get":
+			if err := menu.agdaWin.Ctl("put"); err != nil {
+				log.Printf("could save file: %s", err)
+			}
+			menu.configMu.RLock()
+			reloadOnPut := menu.ReloadOnPut
+			menu.configMu.RUnlock()
+			if !reloadOnPut { // otherwise the put triggers the reload
+				menu.reload()
+			}
+		case "Case":
+			if goalIdx, goalContent, err := menu.selectedGoal(); err != nil {
+				log.Printf("%s", err)
+			} else if err := menu.agdaInteraction.CaseSplit(goalIdx, goalContent); err != nil {
+				log.Printf("could not load file: %s", err)
+			}
+		case "Give":
+			if goalIdx, goalContent, err := menu.selectedGoal(); err != nil {
+				log.Printf("%s", err)
+			} else if err := menu.agdaInteraction.Send(agda.Cmd_give{Force: agda.WithoutForce, Goal: goalIdx, Expr: goalContent}); err != nil {
+				log.Printf("could not give: %s", err)
+			}
+		case "Refine":
+			if goalIdx, goalContent, err := menu.selectedGoal(); err != nil {
+				log.Printf("%s", err)
+			} else if err := menu.agdaInteraction.RefineHole(goalIdx, goalContent); err != nil {
+				log.Printf("could not load file: %s", err)
+			}
+		case "Type":
+			if goalIdx, _, err := menu.selectedGoal(); err != nil {
+				log.Printf("%s", err)
+			} else if err := menu.agdaInteraction.GoalType(goalIdx, menu.rewrite()); err != nil {
+				log.Printf("could not query goal type: %s", err)
+			}
+		case "Config":
+			if config, err := loadConfig(filepath.Dir(menu.agdaInteraction.Filename())); err != nil {
+				menu.ShowError(fmt.Errorf("cannot read configuration: %w", err))
+			} else {
+				menu.ShowError(menu.Configure(config))
+			}
+		case "Input":
+			if err := ExpandInput(menu.agdaWin); err != nil {
+				log.Printf("could not expand input: %s", err)
+			}
+		case "Lookup":
+			if arg == "" {
+				if seq, _, _, err := InputBeforeDot(menu.agdaWin); err != nil {
+					log.Printf("could not lookup input: %s", err)
+					return
+				} else {
+					arg = seq
+				}
+			}
+			menu.ShowSymbols(LookupInput(arg))
+		case "Def":
+			if err := menu.agdaWin.Ctl("addr=dot"); err != nil {
+				log.Printf("could not read dot: %s", err)
+			} else if dot, _, err := menu.agdaWin.ReadAddr(); err != nil {
+				log.Printf("could not read dot: %s", err)
+			} else if token, ok := menu.Highlighting.At(dot); !ok || token.DefinitionSite == nil {
+				log.Printf("no definition known at dot, load the file first")
+			} else if err := PlumbEdit(token.DefinitionSite.Filepath, token.DefinitionSite.Position); err != nil {
+				log.Printf("could not show definition: %s", err)
+			}
+		case "Next":
+			NextGoal(menu.agdaWin)
+		case "Goal":
+			ReplaceSelection(menu.agdaWin, "{!!}")
+		default:
+			if iotcm, ok := menu.userCommand(cmd); !ok {
 				menu.menuWin.WriteEvent(event)
+			} else if err := menu.sendUserCommand(cmd, iotcm); err != nil {
+				log.Printf("could not run %s: %s", cmd, err)
 			}
-		}(e)
+		}
+
+	default:
+		menu.menuWin.WriteEvent(event)
+	}
+}
+
+// Called when the Agda window has been put. Reloads the file once no
+// further put happened for ReloadDelay.
+func (menu *Menu) Put() {
+	menu.configMu.RLock()
+	reloadOnPut, delay := menu.ReloadOnPut, menu.ReloadDelay
+	menu.configMu.RUnlock()
+	if !reloadOnPut {
+		return
+	}
+	menu.reloadMu.Lock()
+	defer menu.reloadMu.Unlock()
+	if menu.reloadTimer != nil {
+		menu.reloadTimer.Stop()
+	}
+	menu.reloadTimer = time.AfterFunc(delay, menu.reload)
+}
+
+func (menu *Menu) reload() {
+	menu.configMu.RLock()
+	abort := menu.AbortReload
+	menu.configMu.RUnlock()
+	if abort {
+		if err := menu.agdaInteraction.Abort(); err != nil {
+			log.Printf("could not abort: %s", err)
+		}
+	}
+	if err := menu.agdaInteraction.LoadFile(); err != nil {
+		log.Printf("could not load file: %s", err)
+	}
+}
+
+// Returns the definition site of the occurrence of name closest to dot.
+func (menu *Menu) DefinitionOf(name string) (*agda.DefinitionSite, error) {
+	tokens := menu.Highlighting.Definitions()
+	if len(tokens) == 0 {
+		return nil, errors.New("no definitions known, load the file first")
+	}
+	if err := menu.agdaWin.Ctl("addr=dot"); err != nil {
+		return nil, err
+	}
+	dot, _, err := menu.agdaWin.ReadAddr()
+	if err != nil {
+		return nil, err
 	}
+	body, err := menu.agdaWin.ReadAll("body")
+	if err != nil {
+		return nil, err
+	}
+	text := []rune(string(body))
+	var site *agda.DefinitionSite
+	distance := len(text)
+	for _, token := range tokens {
+		start, end := token.Range[0]-1, token.Range[1]-1
+		if start < 0 || end > len(text) || string(text[start:end]) != name {
+			continue
+		}
+		d := start - dot
+		if d < 0 {
+			d = -d
+		}
+		if d <= distance {
+			site, distance = token.DefinitionSite, d
+		}
+	}
+	if site == nil {
+		return nil, fmt.Errorf("no definition of %s known", name)
+	}
+	return site, nil
+}
+
+// Remembers the interaction points agda reported, in the order of the text.
+func (menu *Menu) SetGoals(points []agda.InteractionId) {
+	menu.goalsMu.Lock()
+	defer menu.goalsMu.Unlock()
+	menu.goals = menu.goals[:0]
+	for _, point := range points {
+		menu.goals = append(menu.goals, point.Id)
+	}
+}
+
+// Returns the position in the text of the goal with the interaction
+// point id and forgets the goal, as it is given.
+func (menu *Menu) giveGoal(id uint) (int, error) {
+	menu.goalsMu.Lock()
+	defer menu.goalsMu.Unlock()
+	for i, goal := range menu.goals {
+		if goal == id {
+			menu.goals = append(menu.goals[:i:i], menu.goals[i+1:]...)
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("goal ?%d is gone, reload the file", id)
+}
+
+// Returns the interaction point id of the goal at position idx of the
+// goals in the text, of which there are count.
+func (menu *Menu) goalId(idx, count int) (int, error) {
+	menu.goalsMu.Lock()
+	defer menu.goalsMu.Unlock()
+	if count != len(menu.goals) {
+		return 0, errors.New("the goals have changed, reload the file")
+	}
+	return int(menu.goals[idx]), nil
+}
+
+// Selects the goal around dot in the Agda window and returns its
+// interaction point id and content without the surrounding {! !}.
+func (menu *Menu) selectedGoal() (int, string, error) {
+	if err := SelectGoal(menu.agdaWin); err != nil {
+		return 0, "", fmt.Errorf("could not select goal: %w", err)
+	}
+	start, end, err := menu.agdaWin.ReadAddr()
+	if err != nil {
+		return 0, "", fmt.Errorf("could not read select goal address: %w", err)
+	}
+	goalRanges, err := GoalRanges(menu.agdaWin)
+	if err != nil {
+		return 0, "", fmt.Errorf("could not get goal rages: %w", err)
+	}
+	goalIdx := -1
+	for i, goalRange := range goalRanges {
+		if goalRange.Start == start && goalRange.End == end {
+			goalIdx = i
+			break
+		}
+	}
+	if goalIdx == -1 {
+		return 0, "", errors.New("move dot inside a goal")
+	}
+	id, err := menu.goalId(goalIdx, len(goalRanges))
+	if err != nil {
+		return 0, "", err
+	}
+	goalContent := menu.agdaWin.Selection()
+	if len(goalContent) >= 4 && strings.HasPrefix(goalContent, "{!") && strings.HasSuffix(goalContent, "!}") {
+		return id, goalContent[2 : len(goalContent)-2], nil
+	}
+	return id, "", nil // a ? has no content
+}
+
+// Deletes the menu window. Loop returns once the window is gone.
+func (menu *Menu) Delete() error {
+	return menu.menuWin.Ctl("delete")
 }
 
 func (menu *Menu) Close() {
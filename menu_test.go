@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"9fans.net/go/acme"
+	"9fans.net/go/plumb"
+	"gitlab.com/neosimsim/acme-agda/agda"
+)
+
+// A buffer safe for concurrent use, to record the commands sent while
+// the menu handles events.
+type syncBuffer struct {
+	sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buffer.String()
+}
+
+// Returns a menu for a window showing source, talking to a client which
+// replays transcript. The commands sent are recorded to the returned buffer.
+func testMenu(t *testing.T, source, transcript string) (*Menu, *fakeWindow, *syncBuffer) {
+	t.Helper()
+	client, err := agda.Replay(strings.NewReader(transcript), "/tmp/Goals.agda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent syncBuffer
+	if err := client.Record(&sent); err != nil {
+		t.Fatal(err)
+	}
+	editWin := newFakeWindow(source)
+	menu, err := newMenu(client, editWin, newFakeWindow(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as if the goals of goalsSource were loaded
+	menu.SetGoals([]agda.InteractionId{{Id: 0}, {Id: 1}, {Id: 2}})
+	return menu, editWin, &sent
+}
+
+func command(text string) *acme.Event {
+	return &acme.Event{C1: 'M', C2: 'x', Text: []byte(text)}
+}
+
+func TestMenuCase(t *testing.T) {
+	menu, editWin, sent := testMenu(t, goalsSource, "# agda 2.6.2\n")
+	editWin.PlaceDot("n !}")
+	menu.execute(command("Case"))
+	if want := `(Cmd_make_case 0 noRange " n ")`; !strings.Contains(sent.String(), want) {
+		t.Errorf("sent\n%s\nwant %s", sent, want)
+	}
+}
+
+func TestMenuNextAndGoal(t *testing.T) {
+	menu, editWin, _ := testMenu(t, goalsSource, "# agda 2.6.2\n")
+	menu.execute(command("Next"))
+	menu.execute(command("Next"))
+	if got := editWin.Dot(); got != " ?" {
+		t.Fatalf("Next selected %q, want the second goal", got)
+	}
+	menu.execute(command("Goal"))
+	if !strings.Contains(editWin.Body(), "g n ={!!}\n") {
+		t.Errorf("Goal did not replace the selection:\n%s", editWin.Body())
+	}
+}
+
+func TestMenuInput(t *testing.T) {
+	menu, editWin, _ := testMenu(t, "f : Nat \\to", "# agda 2.6.2\n")
+	editWin.SetDot(11, 11)
+	menu.execute(command("Input"))
+	if got := editWin.Body(); got != "f : Nat →" {
+		t.Errorf("got %q after Input", got)
+	}
+	if editWin.dot != (Range{Start: 9, End: 9}) {
+		t.Errorf("dot is %v, want it after the inserted text", editWin.dot)
+	}
+}
+
+func TestMenuLookup(t *testing.T) {
+	menu, _, _ := testMenu(t, "", "# agda 2.6.2\n")
+	menu.execute(&acme.Event{C1: 'M', C2: 'x', Text: []byte("Lookup"), Arg: []byte(`\bN`)})
+	if got := menu.menuWin.(*fakeWindow).Body(); !strings.Contains(got, "Symbols:\n\\bN ℕ\n") {
+		t.Errorf("menu shows\n%s", got)
+	}
+}
+
+func TestMenuPassesOtherEvents(t *testing.T) {
+	menu, _, _ := testMenu(t, "", "# agda 2.6.2\n")
+	event := command("Undo")
+	menu.execute(event)
+	if written := menu.menuWin.(*fakeWindow).written; len(written) != 1 || written[0] != event {
+		t.Errorf("Undo was not passed back to acme: %v", written)
+	}
+}
+
+func TestCaseSplitReplacesLine(t *testing.T) {
+	const makeCase = `{"kind":"MakeCase","variant":"Function","interactionPoint":{"id":0,"range":[]},"clauses":["f zero = ?","f (suc n) = ?"]}`
+	menu, editWin, _ := testMenu(t, goalsSource, "# agda 2.6.2\n"+
+		"< JSON> \n"+
+		"> load\n"+
+		"< JSON> \n"+
+		"> case\n"+
+		"< "+makeCase+"\n"+
+		"< JSON> \n")
+	go handleResponses(menu.agdaInteraction, menu, editWin)
+	editWin.PlaceDot("n !}")
+	menu.execute(command("Case"))
+	want := strings.Replace(goalsSource, "f n = {! n !}\n", "f zero = ?\nf (suc n) = ?\n", 1)
+	for deadline := time.Now().Add(time.Second); editWin.Body() != want; {
+		if time.Now().After(deadline) {
+			t.Fatalf("got\n%s\nwant\n%s", editWin.Body(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHandleResponsesUntilClosed(t *testing.T) {
+	menu, editWin, _ := testMenu(t, goalsSource, "# agda 2.6.2\n")
+	done := make(chan struct{})
+	go func() {
+		handleResponses(menu.agdaInteraction, menu, editWin)
+		close(done)
+	}()
+	menu.agdaInteraction.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handleResponses did not return for the closed client")
+	}
+}
+
+func TestGoalIds(t *testing.T) {
+	const give = `{"kind":"GiveAction","giveResult":{"str":"suc ?"},"interactionPoint":{"id":5,"range":[]}}`
+	menu, editWin, sent := testMenu(t, goalsSource, "# agda 2.6.2\n"+
+		"< JSON> \n"+
+		"> give\n"+
+		"< "+give+"\n"+
+		"< JSON> \n")
+	// agda numbers the goals in the order they were created, not in the
+	// order of the text
+	menu.SetGoals([]agda.InteractionId{{Id: 3}, {Id: 7}, {Id: 5}})
+	go handleResponses(menu.agdaInteraction, menu, editWin)
+	q := len([]rune(goalsSource[:strings.Index(goalsSource, "{!!}")])) + 2
+	editWin.SetDot(q, q)
+	menu.execute(command("Give"))
+	if want := `(Cmd_give WithoutForce 5 noRange "")`; !strings.Contains(sent.String(), want) {
+		t.Errorf("sent\n%s\nwant %s", sent, want)
+	}
+	want := strings.Replace(goalsSource, "h = {!!}", "h = suc ?", 1)
+	for deadline := time.Now().Add(time.Second); editWin.Body() != want; {
+		if time.Now().After(deadline) {
+			t.Fatalf("got\n%s\nwant\n%s", editWin.Body(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExplainName(t *testing.T) {
+	menu, _, sent := testMenu(t, goalsSource, "# agda 2.6.2\n")
+	explainName(menu, &plumb.Message{Data: []byte("zero")})
+	why := &plumb.Message{Data: []byte("zero"), Attr: &plumb.Attribute{Name: "cmd", Value: "why"}}
+	explainName(menu, why)
+	for _, want := range []string{`(Cmd_infer_toplevel Simplified "zero")`, `(Cmd_why_in_scope_toplevel "zero")`} {
+		if !strings.Contains(sent.String(), want) {
+			t.Errorf("sent\n%s\nwant %s", sent, want)
+		}
+	}
+	menu.ShowInfo(agda.Info_InferredType{Expr: "Nat"})
+	if got := menu.menuWin.(*fakeWindow).Body(); !strings.Contains(got, "Type: Nat\n") {
+		t.Errorf("menu shows\n%s", got)
+	}
+}
+
+func TestLoopTag(t *testing.T) {
+	menu, editWin, sent := testMenu(t, goalsSource, "# agda 2.6.2\n")
+	menu.TagCommands = []string{"Case", "Get"}
+	done := make(chan struct{})
+	go func() {
+		menu.LoopTag()
+		close(done)
+	}()
+	editWin.PlaceDot("n !}")
+	get, undo := command("Get"), command("Undo")
+	look := &acme.Event{C1: 'M', C2: 'l', Text: []byte("zero")}
+	typed := &acme.Event{C1: 'K', C2: 'I', Text: []byte("x")}
+	for _, e := range []*acme.Event{command("Case"), get, undo, look, typed} {
+		editWin.events <- e
+	}
+	editWin.Ctl("delete")
+	<-done
+	if editWin.tag != " Case Get" {
+		t.Errorf("tag is %q", editWin.tag)
+	}
+	if written := fmt.Sprint(editWin.written); written != fmt.Sprint([]*acme.Event{get, undo, look}) {
+		t.Errorf("passed back %v, want Get, Undo and the look", editWin.written)
+	}
+	want := `(Cmd_make_case 0 noRange " n ")`
+	for deadline := time.Now().Add(time.Second); !strings.Contains(sent.String(), want); {
+		if time.Now().After(deadline) {
+			t.Fatalf("sent\n%s\nwant %s", sent, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-agda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	menuFile := filepath.Join(dir, "menu")
+	if err := ioutil.WriteFile(menuFile, []byte("{{ join .Commands \" \" }} | {{ .Rewrite }}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const spaced = `IOTCM {{quote .File}} NonInteractive Direct (Cmd_infer_toplevel Simplified "a  \tb")`
+	configFile := filepath.Join(dir, "config")
+	configText := "template " + menuFile + "\n" +
+		"menu Get Normalise\n" +
+		"command Normalise IOTCM {{quote .File}} NonInteractive Direct (Cmd_compute DefaultCompute {{.Goal}} noRange {{quote .Content}})\n" +
+		"command\tSpaced  " + spaced + "\n"
+	if err := ioutil.WriteFile(configFile, []byte(configText), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultConfig()
+	if err := config.ReadFile(configFile); err != nil {
+		t.Fatal(err)
+	}
+	if config.Commands["Spaced"] != spaced {
+		t.Errorf("command Spaced is %q, want %q", config.Commands["Spaced"], spaced)
+	}
+
+	menu, editWin, sent := testMenu(t, goalsSource, "# agda 2.6.2\n")
+	if err := menu.Configure(config); err != nil {
+		t.Fatal(err)
+	}
+	menu.Redraw()
+	if got, want := menu.menuWin.(*fakeWindow).Body(), "Get Normalise | Simplified\n"; got != want {
+		t.Errorf("menu shows %q, want %q", got, want)
+	}
+	editWin.PlaceDot("n !}")
+	menu.execute(command("Normalise"))
+	want := `IOTCM "/tmp/Goals.agda" NonInteractive Direct (Cmd_compute DefaultCompute 0 noRange " n ")`
+	if !strings.Contains(sent.String(), want) {
+		t.Errorf("sent\n%s\nwant %s", sent, want)
+	}
+
+	if err := ioutil.WriteFile(menuFile, []byte("{{ .Unknown }}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := menu.Configure(config); err != nil {
+		t.Fatal(err)
+	}
+	menu.Redraw()
+	if got := menu.menuWin.(*fakeWindow).Body(); !strings.HasPrefix(got, "cannot show menu template: ") || !strings.Contains(got, "Get Normalise") {
+		t.Errorf("menu shows %q for a failing template", got)
+	}
+}
+
+func TestGiveOutsideGoals(t *testing.T) {
+	menu, editWin, sent := testMenu(t, goalsSource, "# agda 2.6.2\n")
+	editWin.PlaceDot("module")
+	menu.execute(command("Give"))
+	if strings.Contains(sent.String(), "Cmd_give") {
+		t.Errorf("gave outside of a goal:\n%s", sent)
+	}
+	q := len([]rune(goalsSource[:strings.Index(goalsSource, "{!!}")])) + 2
+	editWin.SetDot(q, q)
+	menu.execute(command("Give"))
+	if want := `(Cmd_give WithoutForce 2 noRange "")`; !strings.Contains(sent.String(), want) {
+		t.Errorf("sent\n%s\nwant %s", sent, want)
+	}
+}
+
+func TestIsTagCommand(t *testing.T) {
+	menu, _, _ := testMenu(t, goalsSource, "# agda 2.6.2\n")
+	menu.TagCommands = []string{"Give", "Get"}
+	for _, test := range []struct {
+		text string
+		want bool
+	}{
+		{"Give", true},
+		{" Give ", true},
+		{"Get", false},
+		{"", false},
+		{" \t\n", false},
+	} {
+		if got := menu.isTagCommand(command(test.text)); got != test.want {
+			t.Errorf("isTagCommand(%q) is %v, want %v", test.text, got, test.want)
+		}
+	}
+}
+
+// Run with -race: Config may reconfigure the menu while it is redrawn.
+func TestAutoReloadOverride(t *testing.T) {
+	config := DefaultConfig()
+	for _, test := range []struct {
+		values     []string
+		start, put bool
+	}{
+		{[]string{"start", "put"}, true, true},
+		{[]string{"put"}, false, true},
+		{[]string{"off"}, false, false},
+	} {
+		if err := config.set("autoreload", test.values); err != nil {
+			t.Fatal(err)
+		}
+		if config.AutoReloads("start") != test.start || config.AutoReloads("put") != test.put {
+			t.Errorf("autoreload %v reloads on %v", test.values, config.AutoReload)
+		}
+	}
+}
+
+func TestCommandNamedLikeBuiltIn(t *testing.T) {
+	for _, name := range []string{"Give", "Put"} {
+		config := DefaultConfig()
+		if err := config.setCommand(name + " IOTCM {{quote .File}} NonInteractive Direct (Cmd_show_version)"); err == nil {
+			t.Errorf("command %s replaced the built-in command", name)
+		}
+	}
+}
+
+func TestConfigureWhileRedrawing(t *testing.T) {
+	menu, _, _ := testMenu(t, goalsSource, "# agda 2.6.2\n")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if err := menu.Configure(DefaultConfig()); err != nil {
+				t.Error(err)
+			}
+			menu.ShowInfo(agda.Info_InferredType{Expr: "Nat"})
+			menu.ShowError(errors.New("agda exited"))
+			menu.ShowSymbols([]string{"→"})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		menu.Redraw()
+		menu.userCommand("Normalise")
+	}
+	<-done
+}
@@ -0,0 +1,48 @@
+// Talks to the plumber.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plumb"
+)
+
+// Asks the editor to show file at the 1-based character offset pos.
+// The message carries file:#offset like an acme address, so the plumbing
+// rules for files route it, usually to the edit port.
+func PlumbEdit(file string, pos int) error {
+	port, err := plumb.Open("send", plan9.OWRITE)
+	if err != nil {
+		return fmt.Errorf("cannot open plumber: %w", err)
+	}
+	defer port.Close()
+	message := plumb.Message{
+		Src:  "acme-agda",
+		Dir:  filepath.Dir(file),
+		Type: "text",
+		Data: []byte(fmt.Sprintf("%s:#%d", file, pos-1)),
+	}
+	return message.Send(port)
+}
+
+// Calls handle for every message plumbed to portName.
+// Returns when the port cannot be read anymore.
+func ListenPlumb(portName string, handle func(*plumb.Message)) error {
+	port, err := plumb.Open(portName, plan9.OREAD)
+	if err != nil {
+		return fmt.Errorf("cannot open plumb port %s: %w", portName, err)
+	}
+	defer port.Close()
+	reader := bufio.NewReader(port)
+	for {
+		var message plumb.Message
+		if err := message.Recv(reader); err != nil {
+			return fmt.Errorf("cannot read plumb port %s: %w", portName, err)
+		}
+		debugPrint("plumbed to %s: %s", portName, message.Data)
+		handle(&message)
+	}
+}
@@ -0,0 +1,152 @@
+// Lets the subcommands, see cli.go, use the agda process of a running
+// acme-agda. The session listens on the Unix socket acme-agda in the
+// name space directory of plan9port, see namespace(1). Requests and
+// replies are JSON objects, one per line. A request is answered with any
+// number of replies carrying output and a final reply carrying the error,
+// empty on success.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+
+	"9fans.net/go/plan9/client"
+	"gitlab.com/neosimsim/acme-agda/agda"
+)
+
+type reply struct {
+	Output string `json:",omitempty"`
+	Done   bool   `json:",omitempty"`
+	Error  string `json:",omitempty"`
+}
+
+var errNoSession = errors.New("no acme-agda session running")
+
+// Returns the path of the socket name in the name space.
+func namespaceSocket(name string) (string, error) {
+	ns := client.Namespace()
+	if ns == "" {
+		return "", errors.New("cannot determine name space")
+	}
+	return filepath.Join(ns, name), nil
+}
+
+// Listens on the socket name in the name space, unless another
+// acme-agda does already.
+func listenNamespace(name string) (net.Listener, error) {
+	path, err := namespaceSocket(name)
+	if err != nil {
+		return nil, err
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another acme-agda serves %s", path)
+	}
+	os.Remove(path) // left over by a crashed session
+	return net.Listen("unix", path)
+}
+
+// Serves the subcommands with the agda process session returns, which
+// returns nil while there is none. Only one acme-agda serves the
+// subcommands.
+func serveSession(session func() *agda.Client) (io.Closer, error) {
+	listener, err := listenNamespace("acme-agda")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				debugPrint("stopped serving subcommands: %s", err)
+				return
+			}
+			go serveRequest(conn, session())
+		}
+	}()
+	return listener, nil
+}
+
+// Answers the request read from conn using a client sharing the agda
+// process of session.
+func serveRequest(conn net.Conn, session *agda.Client) {
+	defer conn.Close()
+	encoder := json.NewEncoder(conn)
+	var req request
+	if line, err := bufio.NewReader(conn).ReadBytes('\n'); err != nil {
+		log.Printf("cannot read subcommand: %s", err)
+		return
+	} else if err := json.Unmarshal(line, &req); err != nil {
+		encoder.Encode(reply{Error: fmt.Sprintf("malformed request: %s", err)})
+		return
+	}
+	if session == nil {
+		encoder.Encode(reply{Error: "agda is not running yet"})
+		return
+	}
+	file := req.File
+	if file == "" {
+		file = session.Filename()
+	}
+	debugPrint("subcommand %s for %s", req.Command, file)
+	out := replyWriter{encoder}
+	a := session.Open(file)
+	defer a.Close()
+	err := runRequest(a, req, func(r agda.Response) { req.write(out, r) })
+	if err != nil {
+		encoder.Encode(reply{Error: err.Error()})
+	} else {
+		encoder.Encode(reply{Done: true})
+	}
+}
+
+// Writes output as replies.
+type replyWriter struct {
+	encoder *json.Encoder
+}
+
+func (w replyWriter) Write(p []byte) (int, error) {
+	if err := w.encoder.Encode(reply{Output: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Sends req to the running session and copies the output to out.
+// Returns errNoSession if there is no session.
+func sendRequest(req request, out io.Writer) error {
+	path, err := namespaceSocket("acme-agda")
+	if err != nil {
+		return errNoSession
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return errNoSession
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(conn)
+	for {
+		var r reply
+		if err := decoder.Decode(&r); err != nil {
+			return fmt.Errorf("session ended unexpectedly: %w", err)
+		}
+		switch {
+		case r.Error != "":
+			return errors.New(r.Error)
+		case r.Done:
+			return nil
+		default:
+			io.WriteString(out, r.Output)
+		}
+	}
+}
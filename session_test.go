@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"gitlab.com/neosimsim/acme-agda/agda"
+)
+
+func TestSession(t *testing.T) {
+	ns, err := ioutil.TempDir("", "acme-agda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(ns)
+	defer os.Setenv("NAMESPACE", os.Getenv("NAMESPACE"))
+	os.Setenv("NAMESPACE", ns)
+
+	var out bytes.Buffer
+	if err := sendRequest(request{Command: "goals"}, &out); err != errNoSession {
+		t.Fatalf("got %v without session, want errNoSession", err)
+	}
+	a, err := agda.Replay(strings.NewReader(goalsTranscript), "/tmp/Nat.agda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := serveSession(func() *agda.Client { return a })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if _, err := serveSession(func() *agda.Client { return a }); err == nil {
+		t.Error("second session served as well")
+	}
+	if err := sendRequest(request{Command: "case", Args: []string{"0", "n"}}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "f zero = ?\nf (suc n) = ?\n"; out.String() != want {
+		t.Errorf("case printed %q, want %q", out.String(), want)
+	}
+}